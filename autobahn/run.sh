@@ -0,0 +1,43 @@
+#!/usr/bin/env bash
+#
+# runs the Autobahn fuzzingclient against the echo server of pkg/server/autobahn_test.go and fails when a
+# case is not passed. Requires docker, the report is written to autobahn/reports/server/index.html
+set -euo pipefail
+
+cd "$(dirname "$0")"
+
+go test -tags autobahn -run TestAutobahnEchoServer -timeout 0 ../pkg/server &
+server=$!
+trap 'kill $server 2>/dev/null || true' EXIT
+
+# waiting for the echo server to listen
+for _ in $(seq 1 100); do
+    if (exec 3<>/dev/tcp/127.0.0.1/9001) 2>/dev/null; then
+        break
+    fi
+    sleep 0.2
+done
+
+docker run --rm --network host \
+    -v "$PWD:/autobahn" -w /autobahn \
+    crossbario/autobahn-testsuite \
+    wstest -m fuzzingclient -s fuzzingclient.json
+
+# OK, NON-STRICT and INFORMATIONAL are passing behaviours, UNIMPLEMENTED only concerns extensions not negotiated
+python3 - <<'PY'
+import json, sys
+
+with open("reports/server/index.json") as report:
+    results = json.load(report)["brisk"]
+
+failed = sorted(
+    (case for case, result in results.items()
+     if result["behavior"] not in ("OK", "NON-STRICT", "INFORMATIONAL", "UNIMPLEMENTED")
+     or result["behaviorClose"] not in ("OK", "INFORMATIONAL", "UNIMPLEMENTED")),
+    key=lambda case: [int(part) for part in case.split(".")],
+)
+for case in failed:
+    print("failed case %s: %s / %s" % (case, results[case]["behavior"], results[case]["behaviorClose"]))
+print("%d of %d cases passed" % (len(results) - len(failed), len(results)))
+sys.exit(1 if failed else 0)
+PY
@@ -1,6 +1,13 @@
 package main
 
-import "github.com/monodeepdas1215/brisk/pkg/server"
+import (
+	"context"
+	"github.com/monodeepdas1215/brisk/pkg/server"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
 
 func main() {
 
@@ -8,6 +15,26 @@ func main() {
 	configuration.SetRedisHostAddr("localhost:6379")
 
 	socketServer := server.DefaultSocketServer(configuration)
-	socketServer.StartListening()
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	listening := make(chan error, 1)
+	go func() {
+		listening <- socketServer.StartListening()
+	}()
+
+	select {
+
+	// draining the connections when asked to stop
+	case <-signals:
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		socketServer.Shutdown(ctx)
+
+	// the server stops on its own only when it can't listen or accept connections
+	case err := <-listening:
+		server.AppLogger.Errorf("[main] server stopped listening: %v", err)
+		os.Exit(1)
+	}
 }
@@ -4,10 +4,12 @@ go 1.15
 
 require (
 	github.com/go-redis/redis/v8 v8.5.0
-	github.com/gobwas/httphead v0.0.0-20200921212729-da3d93bc3c58 // indirect
+	github.com/gobwas/httphead v0.1.0
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gobwas/ws v1.0.4
+	github.com/gobwas/ws v1.1.0
 	github.com/google/uuid v1.1.2
 	github.com/monodeepdas1215/splash v0.0.0-20200923114740-ddb6df79312a
 	github.com/sirupsen/logrus v1.6.0
+	github.com/vmihailenco/msgpack/v5 v5.1.4
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// captures the token a client presents during the upgrade, in the configured query parameter or header. The
+// header wins over the query parameter when both are present
+type tokenCapture struct {
+	cfg *Configuration
+
+	queryToken  string
+	headerToken string
+}
+
+// ws.Upgrader OnRequest callback
+func (tc *tokenCapture) onRequest(uri []byte) error {
+
+	if tc.cfg.AuthTokenQueryParam == "" {
+		return nil
+	}
+
+	// a malformed URI carries no token, rejecting the upgrade for it is up to the user callbacks
+	parsed, err := url.ParseRequestURI(string(uri))
+	if err != nil {
+		return nil
+	}
+	tc.queryToken = parsed.Query().Get(tc.cfg.AuthTokenQueryParam)
+	return nil
+}
+
+// called with every header of the upgrade request
+func (tc *tokenCapture) onHeader(key, value []byte) {
+	if tc.cfg.AuthTokenHeader != "" && bytes.EqualFold(key, []byte(tc.cfg.AuthTokenHeader)) {
+		tc.headerToken = bearerToken(string(value))
+	}
+}
+
+// captures the token of an upgrade request served by net/http
+func (tc *tokenCapture) fromRequest(r *http.Request) {
+	if tc.cfg.AuthTokenQueryParam != "" {
+		tc.queryToken = r.URL.Query().Get(tc.cfg.AuthTokenQueryParam)
+	}
+	if tc.cfg.AuthTokenHeader != "" {
+		tc.headerToken = bearerToken(r.Header.Get(tc.cfg.AuthTokenHeader))
+	}
+}
+
+func (tc *tokenCapture) token() string {
+	if tc.headerToken != "" {
+		return tc.headerToken
+	}
+	return tc.queryToken
+}
+
+// strips the authentication scheme off an Authorization header value
+func bearerToken(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	return value
+}
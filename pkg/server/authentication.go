@@ -0,0 +1,135 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// disconnects the client unless it is authenticated before the AuthTimeout elapses
+func (ss *SocketServer) startAuthDeadline(client *socketClient) {
+
+	if ss.AuthTimeout <= 0 {
+		return
+	}
+
+	timer := time.AfterFunc(ss.AuthTimeout, func() {
+		if !client.closeUnauthenticated() {
+			return
+		}
+		AppLogger.Infof("[startAuthDeadline] client: %s did not authenticate in time, disconnecting", client.Id)
+		ss.reapClient(client, ws.StatusPolicyViolation, "authentication timeout", ErrAuthenticationTimeout)
+	})
+	client.authTimer.Store(timer)
+}
+
+// authenticates a client by its verified certificate as soon as it connects. A client whose certificate
+// is not accepted may still authenticate with the authentication event
+func (ss *SocketServer) authenticateCertificate(client *socketClient) {
+
+	if !client.transition(clientStateConnected, clientStateAuthenticating) {
+		return
+	}
+
+	req := &AuthRequest{ClientId: client.Id, Certificate: client.certificate, Token: client.token}
+	ok, reason := ss.AuthHandler(req)
+	if !ok {
+		AppLogger.Infof("[authenticateCertificate] client: %s certificate not accepted: %s", client.Id, reason)
+		client.transition(clientStateAuthenticating, clientStateConnected)
+		return
+	}
+	ss.admit(client, req, "")
+}
+
+// handles the first message of a client, which must be the authentication event. The client is disconnected
+// when it sends any other event first or when the AuthHandler rejects it
+func (ss *SocketServer) authenticate(client *socketClient, message *Message) {
+
+	if !AuthenticateMessage(message) {
+		AppLogger.Infof("[authenticate] client: %s sent event: %s before authenticating", client.Id, message.Event)
+		ss.reapClient(client, ws.StatusPolicyViolation, "authentication required", ErrAuthenticationRequired)
+		return
+	}
+
+	if !client.transition(clientStateConnected, clientStateAuthenticating) {
+		return
+	}
+
+	req := &AuthRequest{ClientId: client.Id, Message: message, Certificate: client.certificate, Token: client.token}
+	ok, reason := ss.AuthHandler(req)
+	if !ok {
+		AppLogger.Infof("[authenticate] client: %s authentication rejected: %s", client.Id, reason)
+		ss.reapClient(client, ws.StatusPolicyViolation, reason, ErrAuthenticationFailed)
+		return
+	}
+
+	// subscribing a client to a specific group
+	groupId, _ := message.Payload["group"].(string)
+	ss.admit(client, req, groupId)
+}
+
+// marks the client authenticated and joins it to the group, the default one when no group is given
+func (ss *SocketServer) admit(client *socketClient, req *AuthRequest, groupId string) {
+
+	// the authentication deadline might have elapsed meanwhile
+	if !client.transition(clientStateAuthenticating, clientStateAuthenticated) {
+		return
+	}
+	client.stopAuthDeadline()
+	client.mergeMetadata(req.Metadata)
+	ss.trackExpiry(client, req.ExpiresAt)
+	ss.indexUser(client, req.UserId)
+
+	if groupId == "" {
+		groupId = "default"
+	}
+
+	// joining a group takes the right to subscribe to it on top of joining it, whether or not another client
+	// already opened the group on this node
+	handlerErr := ss.authorize(client, AUTHZ_ACTION_JOIN, groupId, "")
+	if handlerErr == nil {
+		handlerErr = ss.authorize(client, AUTHZ_ACTION_SUBSCRIBE, groupId, "")
+	}
+	if handlerErr != nil {
+		ss.deny(client, handlerErr)
+		return
+	}
+
+	ss.AddGroup(groupId, 100)
+	ss.AddClient(groupId, client)
+}
+
+// authenticates an authenticated client again on its live connection, refreshing its metadata and the expiry
+// of its authentication. A rejected client keeps its previous authentication until it expires
+func (ss *SocketServer) reauthenticate(client *socketClient, message *Message) {
+
+	ctx := newEventContext(ss, client, message)
+	defer ctx.cancel()
+
+	req := &AuthRequest{ClientId: client.Id, Message: message, Certificate: client.certificate, Token: client.token}
+	ok, reason := ss.AuthHandler(req)
+
+	// the connection can't change hands
+	if ok && req.UserId != client.UserId {
+		ok, reason = false, "authenticated as another user"
+	}
+
+	if !ok {
+		AppLogger.Infof("[reauthenticate] client: %s authentication rejected: %s", client.Id, reason)
+		if err := ctx.ReplyError(NewHandlerError(ErrCodeUnauthorized, reason)); err != nil {
+			AppLogger.Errorf("[reauthenticate] client: %s error occurred while sending error frame: %v", client.Id, err)
+		}
+		return
+	}
+
+	client.mergeMetadata(req.Metadata)
+	ss.trackExpiry(client, req.ExpiresAt)
+
+	payload := map[string]interface{}{}
+	if !req.ExpiresAt.IsZero() {
+		payload["expires_at"] = req.ExpiresAt.Unix()
+	}
+	if err := ctx.Reply(payload); err != nil {
+		AppLogger.Errorf("[reauthenticate] client: %s error occurred while acknowledging authentication: %v", client.Id, err)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// server reporting the disconnections of its clients, replying to the echo event
+func newAuthTestServer(t *testing.T, cfg *Configuration) (*SocketServer, string, chan error) {
+
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+	ss.On("echo", func(ctx *EventContext) error {
+		return ctx.Reply(ctx.Payload())
+	})
+	disconnected := make(chan error, 1)
+	ss.OnClientDisconnected = func(clientId string, err error) { disconnected <- err }
+
+	srv := httptest.NewServer(ss)
+	t.Cleanup(srv.Close)
+	return ss, "ws" + strings.TrimPrefix(srv.URL, "http"), disconnected
+}
+
+// connects a websocket client without authenticating it
+func dialUnauthenticated(t *testing.T, url string) net.Conn {
+
+	conn, _, _, err := ws.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// reads the messages sent to the client until the server closes the connection
+func readUntilClosed(t *testing.T, conn net.Conn) (messages []Message, closed wsutil.ClosedError) {
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		data, err := wsutil.ReadServerText(conn)
+		if errors.As(err, &closed) {
+			return messages, closed
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, msg)
+	}
+}
+
+// reads the next message sent to the client
+func readMessage(t *testing.T, conn net.Conn) Message {
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := wsutil.ReadServerText(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestUnauthenticatedClientsClosed(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		send   string
+		reason string
+		err    error
+	}{
+		{"authentication timeout", "", "authentication timeout", ErrAuthenticationTimeout},
+		{"other event first", `{"event":"echo","id":"1","payload":{}}`, "authentication required", ErrAuthenticationRequired},
+		{"authentication rejected", `{"event":"authenticate","payload":{"token":"bad"}}`, "bad token", ErrAuthenticationFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ss, url, disconnected := newAuthTestServer(t, DefaultServerConfiguration(":0").SetAuthTimeout(200*time.Millisecond))
+			ss.AuthHandler = func(req *AuthRequest) (bool, string) {
+				token, _ := req.Message.Payload["token"].(string)
+				return token == "good", "bad token"
+			}
+
+			conn := dialUnauthenticated(t, url)
+			if tt.send != "" {
+				if err := wsutil.WriteClientText(conn, []byte(tt.send)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// nothing is handled before the client is closed
+			messages, closed := readUntilClosed(t, conn)
+			if len(messages) != 0 {
+				t.Fatalf("got messages %+v before the close frame", messages)
+			}
+			if closed.Code != ws.StatusPolicyViolation || closed.Reason != tt.reason {
+				t.Fatalf("closed with %d %q, want %d %q", closed.Code, closed.Reason, ws.StatusPolicyViolation, tt.reason)
+			}
+
+			select {
+			case err := <-disconnected:
+				if err != tt.err {
+					t.Fatalf("disconnected with %v, want %v", err, tt.err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("disconnection not reported")
+			}
+		})
+	}
+}
+
+func TestAuthenticatedClientKeptPastAuthTimeout(t *testing.T) {
+
+	ss, url, disconnected := newAuthTestServer(t, DefaultServerConfiguration(":0").SetAuthTimeout(200*time.Millisecond))
+	ss.AuthHandler = func(req *AuthRequest) (bool, string) { return true, "welcome" }
+
+	conn := dialUnauthenticated(t, url)
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"authenticate","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(400 * time.Millisecond)
+
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"echo","id":"1","payload":{"text":"hi"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if reply := readMessage(t, conn); reply.Event != "echo" || reply.ReplyTo != "1" || reply.Payload["text"] != "hi" {
+		t.Fatalf("got %s replying to %s: %v", reply.Event, reply.ReplyTo, reply.Payload)
+	}
+
+	select {
+	case err := <-disconnected:
+		t.Fatalf("authenticated client disconnected: %v", err)
+	default:
+	}
+}
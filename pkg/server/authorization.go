@@ -0,0 +1,288 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gobwas/ws"
+)
+
+const (
+
+	// joining a group on authentication
+	AUTHZ_ACTION_JOIN = "join"
+
+	// subscribing to the broadcasts of a group, asked on behalf of every joining client after joining
+	AUTHZ_ACTION_SUBSCRIBE = "subscribe"
+
+	// sending an event to the server
+	AUTHZ_ACTION_PUBLISH = "publish"
+
+)
+
+// what a client asks to be authorized for
+type AuthzAction string
+
+// everything known about a client and what it asks for when authorizing it
+type AuthzRequest struct {
+	ClientId	string
+	UserId		string
+	Action		AuthzAction
+
+	// group joined or subscribed to, or the group of the client publishing
+	Group		string
+
+	// event published, empty when joining or subscribing
+	Event		string
+
+	// copy of the metadata of the client, holding what the AuthHandler attached to it
+	Metadata	map[string]interface{}
+}
+
+// allows everything
+func DefaultAuthorizer(req *AuthzRequest) error {
+	return nil
+}
+
+// declarative rule of an AuthzRules set. A rule matches a request when the action, the group, the event and
+// the claims all match, an empty list matching anything.
+//
+// The group and event patterns use the path.Match syntax, and may reference a claim of the client between
+// braces, which stands for any of its values: "user-{jwt_subject}" only matches the group of the client's own
+// subject and "{jwt_groups}" any of the groups of its token
+type AuthzRule struct {
+	Actions	[]AuthzAction		`json:"actions,omitempty"`
+	Groups	[]string			`json:"groups,omitempty"`
+	Events	[]string			`json:"events,omitempty"`
+
+	// patterns a claim of the client must match, by claim name
+	Claims	map[string]string	`json:"claims,omitempty"`
+
+	// whether a matching request is allowed or denied
+	Allow	bool				`json:"allow"`
+}
+
+// ordered rule set, the first rule matching a request decides on it and Default decides on the requests no
+// rule matches. Its Authorize method is meant to be set as the Authorizer callback of the server:
+//
+//	rules := &server.AuthzRules{Rules: []server.AuthzRule{
+//		{Actions: []server.AuthzAction{server.AUTHZ_ACTION_JOIN}, Groups: []string{"{jwt_groups}"}, Allow: true},
+//		{Actions: []server.AuthzAction{server.AUTHZ_ACTION_PUBLISH}, Events: []string{"admin.*"}, Claims: map[string]string{"role": "admin"}, Allow: true},
+//		{Actions: []server.AuthzAction{server.AUTHZ_ACTION_PUBLISH}, Events: []string{"admin.*"}, Allow: false},
+//	}, Default: true}
+//	socketServer.Authorizer = rules.Authorize
+type AuthzRules struct {
+	Rules	[]AuthzRule	`json:"rules"`
+	Default	bool		`json:"default"`
+}
+
+// parses a rule set from its JSON form
+func ParseAuthzRules(data []byte) (*AuthzRules, error) {
+
+	var rules AuthzRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules.Rules {
+		for _, pattern := range append(append([]string{}, rule.Groups...), rule.Events...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern: %s: %v", pattern, err)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// Authorizer callback implementation
+func (ar *AuthzRules) Authorize(req *AuthzRequest) error {
+
+	allow := ar.Default
+	for i := range ar.Rules {
+		if ar.Rules[i].matches(req) {
+			allow = ar.Rules[i].Allow
+			break
+		}
+	}
+
+	if allow {
+		return nil
+	}
+	return deniedError(req)
+}
+
+func (rule *AuthzRule) matches(req *AuthzRequest) bool {
+
+	if len(rule.Actions) > 0 && !containsAction(rule.Actions, req.Action) {
+		return false
+	}
+	if len(rule.Groups) > 0 && !matchesAnyPattern(rule.Groups, req.Group, req.Metadata) {
+		return false
+	}
+
+	// the events only restrict the requests publishing one
+	if len(rule.Events) > 0 && (req.Action != AUTHZ_ACTION_PUBLISH || !matchesAnyPattern(rule.Events, req.Event, req.Metadata)) {
+		return false
+	}
+
+	for claim, pattern := range rule.Claims {
+		if !matchesAnyValue(pattern, claimValues(req.Metadata, claim)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAction(actions []AuthzAction, action AuthzAction) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the value matches one of the patterns once their claim references are expanded
+func matchesAnyPattern(patterns []string, value string, metadata map[string]interface{}) bool {
+	for _, pattern := range patterns {
+		for _, expanded := range expandClaims(pattern, metadata) {
+			if matched, _ := path.Match(expanded, value); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAnyValue(pattern string, values []string) bool {
+	for _, value := range values {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// the patterns obtained by replacing the first claim reference of the pattern by each of the claim values,
+// recursively. A reference to a claim the client does not hold expands to nothing, matching no value
+func expandClaims(pattern string, metadata map[string]interface{}) []string {
+
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return []string{pattern}
+	}
+	end := strings.IndexByte(pattern[start:], '}')
+	if end < 0 {
+		return []string{pattern}
+	}
+	end += start
+
+	var expanded []string
+	for _, value := range claimValues(metadata, pattern[start+1:end]) {
+		// a claim value is matched literally
+		literal := escapePattern(value)
+		expanded = append(expanded, expandClaims(pattern[:start]+literal+pattern[end+1:], metadata)...)
+	}
+	return expanded
+}
+
+// escapes the characters path.Match gives a meaning to
+func escapePattern(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// values of the claim as strings, looked up among the metadata of the client then among the claims of its
+// token. A list claim has each of its items as value
+func claimValues(metadata map[string]interface{}, claim string) []string {
+
+	value, ok := metadata[claim]
+	if !ok {
+		if claims, isMap := metadata[MetadataJWTClaims].(map[string]interface{}); isMap {
+			value, ok = claims[claim]
+		}
+	}
+	if !ok || value == nil {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// error sent back to a client denied the request
+func deniedError(req *AuthzRequest) *HandlerError {
+	switch req.Action {
+	case AUTHZ_ACTION_PUBLISH:
+		return NewHandlerError(ErrCodeForbidden, "not allowed to publish event: "+req.Event)
+	case AUTHZ_ACTION_SUBSCRIBE:
+		return NewHandlerError(ErrCodeForbidden, "not allowed to subscribe to group: "+req.Group)
+	}
+	return NewHandlerError(ErrCodeForbidden, "not allowed to join group: "+req.Group)
+}
+
+// asks the Authorizer whether the client may perform the action, returns the error to send back to the
+// client when it may not
+func (ss *SocketServer) authorize(client *socketClient, action AuthzAction, groupId, event string) *HandlerError {
+
+	if ss.Authorizer == nil {
+		return nil
+	}
+
+	req := &AuthzRequest{
+		ClientId: client.Id,
+		UserId:   client.UserId,
+		Action:   action,
+		Group:    groupId,
+		Event:    event,
+		Metadata: client.metadataSnapshot(),
+	}
+
+	err := ss.Authorizer(req)
+	if err == nil {
+		return nil
+	}
+
+	AppLogger.Infof("[authorize] client: %s denied %s group: %s event: %s: %v", client.Id, action, groupId, event, err)
+
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr
+	}
+	return NewHandlerError(ErrCodeForbidden, err.Error())
+}
+
+// sends the error frame of the denied authentication event to the client and disconnects it. The frame is
+// written right away rather than queued, since the queue is discarded when the connection closes
+func (ss *SocketServer) deny(client *socketClient, handlerErr *HandlerError) {
+
+	data := client.encoder.Encode(Message{Event: ErrorEvent, Payload: map[string]interface{}{
+		"event":   AuthenticationEvent,
+		"code":    handlerErr.Code,
+		"message": handlerErr.Message,
+	}})
+	if data != nil {
+		if err := client.writeMessage(outboundMessage{data: data, opCode: client.encoder.OpCode()}); err != nil {
+			AppLogger.Debugf("[deny] client: %s error occurred while sending error frame: %v", client.Id, err)
+		}
+	}
+	ss.reapClient(client, ws.StatusPolicyViolation, handlerErr.Message, ErrAuthorizationDenied)
+}
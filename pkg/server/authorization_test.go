@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestAuthzRules(t *testing.T) {
+
+	rules := &AuthzRules{Rules: []AuthzRule{
+		{Actions: []AuthzAction{AUTHZ_ACTION_PUBLISH}, Events: []string{"admin.*"}, Claims: map[string]string{"role": "admin"}, Allow: true},
+		{Actions: []AuthzAction{AUTHZ_ACTION_PUBLISH}, Events: []string{"admin.*"}, Allow: false},
+		{Actions: []AuthzAction{AUTHZ_ACTION_JOIN, AUTHZ_ACTION_SUBSCRIBE}, Groups: []string{"user-{jwt_subject}"}, Allow: true},
+		{Actions: []AuthzAction{AUTHZ_ACTION_JOIN, AUTHZ_ACTION_SUBSCRIBE}, Groups: []string{"team-{teams}"}, Allow: true},
+		{Actions: []AuthzAction{AUTHZ_ACTION_JOIN}, Groups: []string{"{jwt_groups}"}, Allow: true},
+		{Groups: []string{"private-*"}, Allow: false},
+		{Actions: []AuthzAction{AUTHZ_ACTION_JOIN, AUTHZ_ACTION_SUBSCRIBE}, Allow: false},
+	}, Default: true}
+
+	alice := map[string]interface{}{
+		MetadataJWTSubject: "alice",
+		MetadataJWTGroups:  []string{"staff", "ops"},
+		MetadataJWTClaims: map[string]interface{}{
+			"role":  "admin",
+			"teams": []interface{}{"red", "blue"},
+		},
+	}
+	wildcard := map[string]interface{}{MetadataJWTSubject: "*", MetadataJWTGroups: []string{"st?ff", "[a-z]*"}}
+
+	tests := []struct {
+		name     string
+		action   AuthzAction
+		group    string
+		event    string
+		metadata map[string]interface{}
+		allowed  bool
+	}{
+		{"admin publishing an admin event", AUTHZ_ACTION_PUBLISH, "default", "admin.kick", alice, true},
+		{"client without the claim publishing an admin event", AUTHZ_ACTION_PUBLISH, "default", "admin.kick", nil, false},
+		{"publishing another event", AUTHZ_ACTION_PUBLISH, "private-room", "chat", nil, false},
+		{"publishing an event left to the default", AUTHZ_ACTION_PUBLISH, "default", "chat", nil, true},
+		{"joining the group of the own subject", AUTHZ_ACTION_JOIN, "user-alice", "", alice, true},
+		{"subscribing to the group of the own subject", AUTHZ_ACTION_SUBSCRIBE, "user-alice", "", alice, true},
+		{"joining the group of another subject", AUTHZ_ACTION_JOIN, "user-bob", "", alice, false},
+		{"joining a group of a list claim of the token", AUTHZ_ACTION_JOIN, "team-blue", "", alice, true},
+		{"joining a group missing from a list claim", AUTHZ_ACTION_JOIN, "team-green", "", alice, false},
+		{"joining a group of the token", AUTHZ_ACTION_JOIN, "ops", "", alice, true},
+		{"subscribing to a group of the token", AUTHZ_ACTION_SUBSCRIBE, "ops", "", alice, false},
+		{"joining a group without claims", AUTHZ_ACTION_JOIN, "user-", "", nil, false},
+		{"claim values matched literally", AUTHZ_ACTION_JOIN, "user-bob", "", wildcard, false},
+		{"claim value holding a pattern matched literally", AUTHZ_ACTION_JOIN, "user-*", "", wildcard, true},
+		{"list claim values matched literally", AUTHZ_ACTION_JOIN, "staff", "", wildcard, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := rules.Authorize(&AuthzRequest{Action: tt.action, Group: tt.group, Event: tt.event, Metadata: tt.metadata})
+			if (err == nil) != tt.allowed {
+				t.Fatalf("got %v, want allowed %v", err, tt.allowed)
+			}
+			if err != nil {
+				var handlerErr *HandlerError
+				if !errors.As(err, &handlerErr) || handlerErr.Code != ErrCodeForbidden {
+					t.Fatalf("got error %#v, want a %s handler error", err, ErrCodeForbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthzRulesDefault(t *testing.T) {
+
+	req := &AuthzRequest{Action: AUTHZ_ACTION_JOIN, Group: "default"}
+	if err := (&AuthzRules{Default: true}).Authorize(req); err != nil {
+		t.Fatalf("allowing default: got %v", err)
+	}
+	if err := (&AuthzRules{}).Authorize(req); err == nil {
+		t.Fatal("denying default: request allowed")
+	}
+}
+
+func TestExpandClaims(t *testing.T) {
+
+	metadata := map[string]interface{}{
+		"name":            "a*b",
+		"ids":             []interface{}{1, 2},
+		"empty":           nil,
+		MetadataJWTClaims: map[string]interface{}{"name": "shadowed", "org": "acme"},
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"plain", []string{"plain"}},
+		{"{name}", []string{`a\*b`}},
+		{"{org}-{ids}", []string{"acme-1", "acme-2"}},
+		{"{ids}/{ids}", []string{"1/1", "1/2", "2/1", "2/2"}},
+		{"{missing}", nil},
+		{"{empty}", nil},
+		{"{unterminated", []string{"{unterminated"}},
+	}
+
+	for _, tt := range tests {
+		if got := expandClaims(tt.pattern, metadata); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestParseAuthzRules(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"rules", `{"rules":[{"actions":["join"],"groups":["user-{jwt_subject}"],"allow":true}],"default":false}`, true},
+		{"empty", `{}`, true},
+		{"invalid group pattern", `{"rules":[{"groups":["[a-"],"allow":true}]}`, false},
+		{"invalid event pattern", `{"rules":[{"events":["\\"],"allow":true}]}`, false},
+		{"invalid JSON", `{"rules":`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseAuthzRules([]byte(tt.data))
+			if (err == nil) != tt.valid {
+				t.Fatalf("got %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid && rules == nil {
+				t.Fatal("no rules returned")
+			}
+		})
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		authorizer func(req *AuthzRequest) error
+		want       *HandlerError
+	}{
+		{"no authorizer", nil, nil},
+		{"allowed", DefaultAuthorizer, nil},
+		{
+			name:       "handler error",
+			authorizer: func(req *AuthzRequest) error { return NewHandlerError("quota", "too many groups") },
+			want:       NewHandlerError("quota", "too many groups"),
+		},
+		{
+			name:       "other error",
+			authorizer: func(req *AuthzRequest) error { return errors.New("denied by policy") },
+			want:       NewHandlerError(ErrCodeForbidden, "denied by policy"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ss := &SocketServer{ServerCallbacks: &ServerCallbacks{}}
+			ss.Authorizer = tt.authorizer
+
+			client := newSocketClient("client", nil, &JsonEncoder{})
+			client.mergeMetadata(map[string]interface{}{"role": "admin"})
+
+			got := ss.authorize(client, AUTHZ_ACTION_PUBLISH, "default", "chat")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeAuthorizedOnEveryJoin(t *testing.T) {
+
+	cfg := DefaultServerConfiguration(":0")
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+
+	// only the first client may subscribe to the group
+	var lock sync.Mutex
+	requests := 0
+	subscribed := make(chan string, 2)
+	ss.Authorizer = func(req *AuthzRequest) error {
+		if req.Action != AUTHZ_ACTION_SUBSCRIBE {
+			return nil
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		subscribed <- req.ClientId
+		if requests > 1 {
+			return errors.New("subscriptions closed")
+		}
+		return nil
+	}
+
+	srv := httptest.NewServer(ss)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	join := func() net.Conn {
+		conn, _, _, err := ws.Dial(context.Background(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := wsutil.WriteClientText(conn, []byte(`{"event":"authenticate","payload":{"group":"room"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	join()
+	select {
+	case <-subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribe not authorized for the client opening the group")
+	}
+
+	// the group is served already when the second client joins it
+	second := join()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := wsutil.ReadServerText(second)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Event != ErrorEvent || msg.Payload["code"] != ErrCodeForbidden {
+		t.Fatalf("got %s %v, want the join denied", msg.Event, msg.Payload)
+	}
+}
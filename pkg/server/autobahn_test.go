@@ -0,0 +1,73 @@
+//go:build autobahn
+// +build autobahn
+
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// echo server the Autobahn fuzzingclient runs its cases against, started by autobahn/run.sh. It drives
+// the reader and the writer of the clients directly: a SocketServer only takes JSON encoded events after an
+// authentication message, which the suite does not send
+func TestAutobahnEchoServer(t *testing.T) {
+
+	addr := os.Getenv("AUTOBAHN_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:9001"
+	}
+
+	// the largest messages of the suite are 16MB
+	cfg := DefaultServerConfiguration(addr).SetCompression(true, 0).SetMaxMessageSize(32 << 20)
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("echo server listening on %s", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go echoConnection(ss, conn)
+	}
+}
+
+// sends every message of the connection back with its op code until the connection is closed
+func echoConnection(ss *SocketServer, conn net.Conn) {
+
+	negotiation := &compressionNegotiation{cfg: ss.Configuration}
+	capture := &tokenCapture{cfg: ss.Configuration}
+	if _, err := ss.tcpConnectionUpgrader(negotiation, capture).Upgrade(conn); err != nil {
+		conn.Close()
+		return
+	}
+
+	client := newSocketClient(uuid.New().String(), &conn, ss.encoder)
+	if negotiation.accepted != nil {
+		client.compression = newCompression(*negotiation.accepted, ss.Configuration)
+	}
+	client.reader = newMessageReader(conn, client, ss.Configuration)
+	client.writeTimeout = ss.WriteTimeout
+	client.outbound = newOutboundQueue(ss.OutboundQueueSize, ss.SlowConsumerPolicy)
+	ss.trackClient(client)
+
+	for {
+		payload, complete, err := client.reader.step()
+		if err != nil {
+			ss.closeOnReadError(client, err)
+			return
+		}
+		if complete {
+			client.PushData(payload, client.reader.opCode)
+		}
+	}
+}
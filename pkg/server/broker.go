@@ -0,0 +1,43 @@
+package server
+
+import "context"
+
+const (
+
+	BROKER_TYPE_MEMORY = "memory"
+	BROKER_TYPE_REDIS = "redis"
+	BROKER_TYPE_REDIS_STREAMS = "redis_streams"
+
+)
+
+type BrokerType string
+
+// Broker carries group broadcasts between the server nodes
+type Broker interface {
+
+	// publishes data on the channel to every subscriber of it
+	Publish(ctx context.Context, channel string, data []byte) error
+
+	// subscribes to the channel, every published data is delivered on the returned go channel
+	// until Unsubscribe or Close is called
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+
+	// cancels the subscription to the channel and closes the go channel returned by Subscribe
+	Unsubscribe(ctx context.Context, channel string) error
+
+	// cancels every subscription and releases the resources held by the broker
+	Close() error
+}
+
+// creates the broker selected in the Configuration
+func NewBroker(cfg *Configuration) Broker {
+
+	switch cfg.BrokerType {
+	case BROKER_TYPE_MEMORY:
+		return NewMemoryBroker(cfg.BroadcastMessagesLimit)
+	case BROKER_TYPE_REDIS_STREAMS:
+		return NewRedisStreamBroker(GetRedisClient(cfg), cfg.BroadcastMessagesLimit, cfg.RedisStreamMaxLength)
+	default:
+		return NewRedisBroker(GetRedisClient(cfg), cfg.BroadcastMessagesLimit)
+	}
+}
@@ -1,6 +1,36 @@
 package server
 
-import "github.com/gobwas/ws"
+import (
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// everything known about a client when authenticating it
+type AuthRequest struct {
+
+	ClientId	string
+
+	// the authentication message sent by the client, nil when authenticating by certificate on connection
+	Message		*Message
+
+	// verified TLS client certificate of the connection, nil without mutual TLS
+	Certificate	*ClientCertificate
+
+	// token presented during the upgrade in the AuthTokenQueryParam query parameter or the AuthTokenHeader
+	// header, empty when none was
+	Token		string
+
+	// set by the handler to the stable id of the user, shared by the clients of the user on all its devices
+	UserId		string
+
+	// set by the handler to the metadata to attach to the client once authenticated
+	Metadata	map[string]interface{}
+
+	// set by the handler to when the authentication expires, the client is disconnected then unless it
+	// authenticates again. Zero never expires
+	ExpiresAt	time.Time
+}
 
 type ServerCallbacks struct {
 
@@ -8,7 +38,7 @@ type ServerCallbacks struct {
 	OnClientConnected		func(clientId string)
 
 	// authentication handler callback
-	AuthHandler				func(clientId string, msg Message) (bool, string)
+	AuthHandler				func(req *AuthRequest) (bool, string)
 
 	// on message is received
 	OnMessageReceived		func(clientId string, msg Message, err error)
@@ -24,10 +54,17 @@ type ServerCallbacks struct {
 
 	// called just before connection is upgraded to websocket
 	OnBeforeUpgrade			func() (header ws.HandshakeHeader, err error)
+
+	// called when the outbound queue of a client overflows, with the policy applied to it
+	OnSlowConsumer			func(clientId string, policy SlowConsumerPolicy)
+
+	// decides whether a client may join or subscribe to a group or publish an event, nil allows it. A
+	// HandlerError is sent back to the client as it is, any other error as a forbidden error
+	Authorizer				func(req *AuthzRequest) error
 }
 
 func NewServerCallbacks(onClientConnected func(clientId string),
-	authHandler func(clientId string, msg Message) (bool, string),
+	authHandler func(req *AuthRequest) (bool, string),
 	onMessageReceived func(clientId string, msg Message, err error),
 	onClientDisconnected func(clientId string, err error),
 	onHostConnectHandler func(host []byte) error,
@@ -78,6 +115,9 @@ func NewServerCallbacks(onClientConnected func(clientId string),
 		callback.OnBeforeUpgrade = onBeforeUpgrade
 	}
 
+	callback.OnSlowConsumer = DefaultOnSlowConsumer
+	callback.Authorizer = DefaultAuthorizer
+
 	return callback
 }
 
@@ -85,7 +125,7 @@ func DefaultOnClientConnected(clientId string) {
 	AppLogger.Infof("[DefaultOnClientConnected] new client connected: %s", clientId)
 }
 
-func DefaultAuthHandler(clientId string, msg Message) (bool, string) {
+func DefaultAuthHandler(req *AuthRequest) (bool, string) {
 	AppLogger.Infof("[DefaultAuthHandler] default auth handler being used, doing nothing except forwarding requests")
 	return true, "default auth handler"
 }
@@ -120,3 +160,7 @@ func DefaultOnBeforeUpgrade() (header ws.HandshakeHeader, err error) {
 	AppLogger.Infoln("[DefaultOnBeforeUpgrade] using default onBeforeUpgrade handler")
 	return header, err
 }
+
+func DefaultOnSlowConsumer(clientId string, policy SlowConsumerPolicy) {
+	AppLogger.Warnf("[DefaultOnSlowConsumer] client: %s does not keep up with its messages, applying policy: %s", clientId, policy)
+}
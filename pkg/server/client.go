@@ -3,35 +3,138 @@ package server
 import (
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
+// time given to the close frame to be written before closing the connection anyway
+const closeFrameWriteTimeout = time.Second
+
 // structure holding information about an incoming websocket connection
 type socketClient struct {
 
+	// identifies the connection, a user connected from several devices has a client of each
 	Id                   string
+
+	// stable id of the user the client authenticated as, set by the AuthHandler. Empty until authenticated
+	// or when the AuthHandler sets none
+	UserId				 string
+
+	metadataLock		 sync.RWMutex
 	metadata			 map[string]interface{}
+	group				 *group
+
+	// requests sent by the server to the client which are waiting for their reply, by message id
+	pendingLock			 sync.Mutex
+	pendingRequests		 map[string]chan *Message
+
+	// closed once the connection closes, failing the pending requests which will never get their reply
+	done				 chan struct{}
+
 	socket               *net.Conn
-	broadCastReceiveChan chan interface{}
-	stopBroadcastChan	 chan interface{}
+
+	// encoder negotiated for the connection, every message pushed to the client is encoded with it
+	encoder				 IEncoder
+
+	// permessage-deflate state, nil when the client did not negotiate the extension
+	compression			 *compression
+
+	// serializes the writes of the handlers, the broadcasts and the server requests on the socket
+	writeLock			 sync.Mutex
+
+	// reader of the messages of the connection. Only used by whoever reads the connection, a goroutine of
+	// its own or a worker of the netpoll
+	reader				 *messageReader
+
+	// clientState of the connection, and the timer disconnecting the client unless it authenticates in time
+	state				 int32
+	authTimer			 atomic.Value
+
+	// expiry of the identity the client authenticated with, zero when it never expires, and the timer
+	// warning then disconnecting the client
+	expiryLock			 sync.Mutex
+	expiresAt			 time.Time
+	expiryTimer			 *time.Timer
+
+	// verified TLS client certificate, nil without mutual TLS
+	certificate			 *ClientCertificate
+
+	// token presented during the upgrade, empty when none was
+	token				 string
+
+	// registration of the connection to the netpoll, nil when the connection is read by a goroutine of its own
+	polled				 *polledConn
+
+	// messages waiting to be written, drained by a writer goroutine running only while there are some
+	outbound			 *outboundQueue
+
+	// handlings of the messages read, run one at a time on the handling pool in the order they arrived
+	inbound				 *serialQueue
+
+	// time given to every write before the client is considered dead, 0 waits forever
+	writeTimeout		 time.Duration
+
+	// makes sure the connection is only closed once whoever closes it first
+	closeOnce			 sync.Once
+
+	// unix nano times of the last frame of any kind, of the last message and of the last ping sent
+	lastSeen			 int64
+	lastActive			 int64
+	lastPing			 int64
+
+	// set once the heartbeat decided to disconnect the client
+	reaped				 int32
+
+	// reason the server closed the connection for, reported on disconnection over whatever error
+	// the reading side of the connection runs into afterwards
+	closeReason			 atomic.Value
 }
 
-func newSocketClient(tmpId string, socketObj *net.Conn) *socketClient {
+func newSocketClient(tmpId string, socketObj *net.Conn, encoder IEncoder) *socketClient {
 	client := &socketClient{
 		Id:            tmpId,
 		socket:        socketObj,
-		broadCastReceiveChan: make(chan interface{}, 20),
-		stopBroadcastChan: make(chan interface{}),
+		encoder:       encoder,
+		lastSeen:      time.Now().UnixNano(),
+		lastActive:    time.Now().UnixNano(),
+		lastPing:      time.Now().UnixNano(),
+		pendingRequests: make(map[string]chan *Message),
+		done:            make(chan struct{}),
 	}
 	return client
 }
 
 func (cl *socketClient) SetMetadata(meta map[string]interface{}) {
+	cl.metadataLock.Lock()
 	cl.metadata = meta
+	cl.metadataLock.Unlock()
+}
+
+// adds the entries to the metadata of the client, replacing the ones with the same keys
+func (cl *socketClient) mergeMetadata(meta map[string]interface{}) {
+	if len(meta) == 0 {
+		return
+	}
+
+	cl.metadataLock.Lock()
+	if cl.metadata == nil {
+		cl.metadata = make(map[string]interface{}, len(meta))
+	}
+	for key, value := range meta {
+		cl.metadata[key] = value
+	}
+	cl.metadataLock.Unlock()
 }
 
 func (cl *socketClient) GetMetadata(key string) interface{} {
 
+	cl.metadataLock.RLock()
+	defer cl.metadataLock.RUnlock()
+
 	val, ok := cl.metadata[key]
 	if !ok {
 		return nil
@@ -39,44 +142,192 @@ func (cl *socketClient) GetMetadata(key string) interface{} {
 	return val
 }
 
-// subscription goroutine for receiving group broadcasts
-func (cl *socketClient) ListenToGroupBroadcast() {
+// copy of the metadata of the client
+func (cl *socketClient) metadataSnapshot() map[string]interface{} {
+
+	cl.metadataLock.RLock()
+	defer cl.metadataLock.RUnlock()
+
+	snapshot := make(map[string]interface{}, len(cl.metadata))
+	for key, value := range cl.metadata {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// queues the data for the client and returns error if it could not be queued. Control frames are
+// not queued behind the messages but written right away
+func (cl *socketClient) PushData(data []byte, opCode ws.OpCode) error {
+	if opCode.IsControl() {
+		return cl.pushControl(opCode, data, cl.writeTimeout)
+	}
+	return cl.enqueue(outboundMessage{data: data, opCode: opCode})
+}
+
+// queues the frame prepared for every client sharing the encoder of this one
+func (cl *socketClient) pushPrepared(pm *preparedMessage) error {
+	return cl.enqueue(outboundMessage{prepared: pm})
+}
+
+// writes the data, compressing it when the client negotiated compression. Must hold the write lock
+func (cl *socketClient) writeData(data []byte, opCode ws.OpCode) error {
+
+	if cl.compression != nil && cl.compression.shouldCompress(len(data)) {
+		compressed, err := cl.compression.compress(data)
+		if err != nil {
+			return err
+		}
+		return writeCompressedMessage(*cl.socket, opCode, compressed)
+	}
+	return wsutil.WriteServerMessage(*cl.socket, opCode, data)
+}
 
-	go func() {
-		for {
-			select {
-			case groupBroadcastData := <- cl.broadCastReceiveChan:
-				AppLogger.logger.Infof("[SubscribeForGroupBroadcast] broadcast received: %v", groupBroadcastData)
+// writes the prepared frame, compressing it when the client negotiated compression. Must hold the write lock
+func (cl *socketClient) writePrepared(pm *preparedMessage) error {
 
-				groupBroadcastDataStr := groupBroadcastData.(string)
-				cl.PushData([]byte(groupBroadcastDataStr), ws.OpText)
+	if c := cl.compression; c != nil && c.shouldCompress(len(pm.payload)) {
 
-			case <-cl.stopBroadcastChan:
-				AppLogger.logger.Infoln("[SubscribeForGroupBroadcast] exiting broadcast")
-				return
+		// without context the compressed frame is the same for every client
+		if c.shareable() {
+			if frame := pm.compressedFrame(c.level); frame != nil {
+				_, err := (*cl.socket).Write(frame)
+				return err
 			}
+		} else {
+			compressed, err := c.compress(pm.payload)
+			if err != nil {
+				return err
+			}
+			return writeCompressedMessage(*cl.socket, pm.opCode, compressed)
 		}
-	}()
+	}
+
+	_, err := (*cl.socket).Write(pm.frame)
+	return err
 }
 
-// pushes the data passed to the socketClient and returns error if any
-func (cl *socketClient) PushData(data []byte, opCode ws.OpCode) error {
-	return wsutil.WriteServerMessage(*cl.socket, opCode, data)
+// writes the compressed message as a single frame flagged with the compression bit
+func writeCompressedMessage(w io.Writer, opCode ws.OpCode, data []byte) error {
+	frame := ws.NewFrame(opCode, true, data)
+	frame.Header.Rsv = ws.Rsv(true, false, false)
+	return ws.WriteFrame(w, frame)
+}
+
+// sends a control frame to the client, giving up once the timeout elapses so that a dead peer
+// does not block the caller
+func (cl *socketClient) pushControl(opCode ws.OpCode, payload []byte, timeout time.Duration) error {
+	cl.writeLock.Lock()
+	defer cl.writeLock.Unlock()
+
+	// a frame following a partially written one would be garbage to the client
+	if !cl.outbound.writable() {
+		return ErrClientClosed
+	}
+
+	conn := *cl.socket
+	if timeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+
+	return wsutil.WriteServerMessage(conn, opCode, payload)
+}
+
+// time given to the queued messages to be written when the connection closes, a client with no write
+// timeout gets the time given to the close frame
+func (cl *socketClient) flushTimeout() time.Duration {
+	if cl.writeTimeout > 0 {
+		return cl.writeTimeout
+	}
+	return closeFrameWriteTimeout
+}
+
+// records why the server is closing the connection, nil when it closes it normally
+func (cl *socketClient) setCloseReason(err error) {
+	cl.closeReason.Store(closeReason{err})
+}
+
+// why the server closed the connection, false when it recorded no reason
+func (cl *socketClient) getCloseReason() (closeReason, bool) {
+	reason, ok := cl.closeReason.Load().(closeReason)
+	return reason, ok
+}
+
+// wrapper giving every stored reason the same concrete type, as atomic.Value requires
+type closeReason struct {
+	err error
+}
+
+func (cl *socketClient) markSeen() {
+	atomic.StoreInt64(&cl.lastSeen, time.Now().UnixNano())
+}
+
+func (cl *socketClient) markActive() {
+	atomic.StoreInt64(&cl.lastActive, time.Now().UnixNano())
+}
+
+// encodes the message with the encoder of the client and pushes it to the client
+func (cl *socketClient) PushMessage(msg Message) error {
+
+	data := cl.encoder.Encode(msg)
+	if data == nil {
+		return ErrEncodingFailed
+	}
+	return cl.PushData(data, cl.encoder.OpCode())
 }
 
 // close all the allocated resource to the client
 func (cl *socketClient) StopClient() error {
+	return cl.closeWithStatus(ws.StatusNormalClosure, "closing connection from server")
+}
 
-	cl.stopBroadcastChan <- 1
-
-	close(cl.stopBroadcastChan)
-	close(cl.broadCastReceiveChan)
+// sends a close frame with the status code and reason to the client, then closes the connection
+// and releases the resources allocated to the client
+func (cl *socketClient) closeWithStatus(code ws.StatusCode, reason string) error {
+	return cl.closeWithBody(ws.NewCloseFrameBody(code, truncateCloseReason(reason)))
+}
 
-	cl.PushData([]byte("closing connection from server"), ws.OpClose)
+// cuts the reason to what fits in a close frame along with the status code, without splitting a character
+func truncateCloseReason(reason string) string {
+	const maxReasonSize = ws.MaxControlFramePayloadSize - 2
 
-	err := (*cl.socket).Close()
-	if err != nil {
-		return err
+	if len(reason) <= maxReasonSize {
+		return reason
+	}
+	cut := maxReasonSize
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
 	}
-	return nil
+	return reason[:cut]
+}
+
+// sends a close frame with the given body, empty when no status code is to be sent, then closes the connection
+func (cl *socketClient) closeWithBody(body []byte) error {
+
+	var err error
+
+	cl.closeOnce.Do(func() {
+
+		cl.markClosing()
+		close(cl.done)
+		cl.stopAuthDeadline()
+		cl.stopExpiry()
+
+		// the descriptor of the connection must not be watched anymore once closed
+		if cl.polled != nil {
+			cl.polled.unwatch()
+		}
+
+		// the messages queued before the close frame are written first
+		cl.outbound.flush(cl.flushTimeout())
+
+		if pushErr := cl.pushControl(ws.OpClose, body, closeFrameWriteTimeout); pushErr != nil {
+			AppLogger.Debugf("[closeWithStatus] client: %s error occurred while sending close frame: %v", cl.Id, pushErr)
+		}
+
+		err = (*cl.socket).Close()
+	})
+	return err
 }
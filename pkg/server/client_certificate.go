@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+const (
+
+	// client metadata keys holding the identity of a verified TLS client certificate
+	MetadataCertSubject = "tls_subject"
+	MetadataCertCommonName = "tls_common_name"
+	MetadataCertSANs = "tls_sans"
+	MetadataCertFingerprint = "tls_fingerprint"
+
+)
+
+// identity of a client which presented a TLS client certificate the server verified
+type ClientCertificate struct {
+
+	// distinguished name of the subject
+	Subject			string
+	CommonName		string
+
+	// subject alternative names: DNS names, email addresses, URIs and IP addresses
+	SANs			[]string
+
+	// hex encoded SHA-256 of the DER encoded certificate
+	Fingerprint		string
+
+	Certificate		*x509.Certificate
+}
+
+func newClientCertificate(cert *x509.Certificate) *ClientCertificate {
+
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	return &ClientCertificate{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		SANs:        sans,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Certificate: cert,
+	}
+}
+
+// the verified client certificate of a TLS connection, nil when the client presented none
+// or the server did not verify it
+func verifiedClientCertificate(state *tls.ConnectionState) *ClientCertificate {
+
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return newClientCertificate(state.VerifiedChains[0][0])
+}
+
+// client metadata describing the certificate
+func (cc *ClientCertificate) metadata() map[string]interface{} {
+	return map[string]interface{}{
+		MetadataCertSubject:     cc.Subject,
+		MetadataCertCommonName:  cc.CommonName,
+		MetadataCertSANs:        cc.SANs,
+		MetadataCertFingerprint: cc.Fingerprint,
+	}
+}
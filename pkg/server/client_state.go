@@ -0,0 +1,65 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// stage of the lifecycle of a client connection, which only ever moves forward
+type clientState int32
+
+const (
+
+	// connected, waiting for the authentication event
+	clientStateConnected clientState = iota
+
+	// the AuthHandler is deciding on the authentication event
+	clientStateAuthenticating
+
+	// accepted by the AuthHandler and joined to a group, its events are handled
+	clientStateAuthenticated
+
+	// being closed, nothing it sends is handled anymore
+	clientStateClosing
+
+)
+
+func (s clientState) String() string {
+	switch s {
+	case clientStateConnected:
+		return "connected"
+	case clientStateAuthenticating:
+		return "authenticating"
+	case clientStateAuthenticated:
+		return "authenticated"
+	case clientStateClosing:
+		return "closing"
+	}
+	return "unknown"
+}
+
+func (cl *socketClient) getState() clientState {
+	return clientState(atomic.LoadInt32(&cl.state))
+}
+
+// moves the client from one state to the next, false when the client is not in the expected state anymore
+func (cl *socketClient) transition(from, to clientState) bool {
+	return atomic.CompareAndSwapInt32(&cl.state, int32(from), int32(to))
+}
+
+// moves the client to the closing state whatever its current state
+func (cl *socketClient) markClosing() {
+	atomic.StoreInt32(&cl.state, int32(clientStateClosing))
+}
+
+// moves a client not authenticated yet to the closing state, false when it is authenticated or closing already
+func (cl *socketClient) closeUnauthenticated() bool {
+	return cl.transition(clientStateConnected, clientStateClosing) ||
+		cl.transition(clientStateAuthenticating, clientStateClosing)
+}
+
+func (cl *socketClient) stopAuthDeadline() {
+	if timer, ok := cl.authTimer.Load().(*time.Timer); ok {
+		timer.Stop()
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// compress/flate always compresses with the largest window the extension allows
+const compressionWindowSize = wsflate.MaxLZ77WindowSize
+
+// a sync flush ends the compressed data with this empty stored block, which the extension leaves out of
+// the message
+var compressionTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// appended to a compressed message to decompress it: the block left out by the sender, followed by a
+// final empty block ending the stream
+var decompressionTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// negotiates permessage-deflate during one upgrade, accepting the first offer it can honour
+type compressionNegotiation struct {
+	cfg      *Configuration
+	accepted *wsflate.Parameters
+}
+
+func (n *compressionNegotiation) negotiate(opt httphead.Option) (httphead.Option, error) {
+
+	if n.accepted != nil || !bytes.Equal(opt.Name, wsflate.ExtensionNameBytes) {
+		return httphead.Option{}, nil
+	}
+
+	// a malformed offer is declined, a following one may still be accepted
+	var offer wsflate.Parameters
+	if err := offer.Parse(opt); err != nil {
+		AppLogger.Debugf("[negotiate] declining compression offer: %v", err)
+		return httphead.Option{}, nil
+	}
+
+	// the window of the server compressor can't be reduced
+	if offer.ServerMaxWindowBits.Defined() && offer.ServerMaxWindowBits.Bytes() < compressionWindowSize {
+		return httphead.Option{}, nil
+	}
+
+	params := wsflate.Parameters{
+		ServerNoContextTakeover: offer.ServerNoContextTakeover || !n.cfg.CompressionServerContextTakeover,
+		ClientNoContextTakeover: offer.ClientNoContextTakeover || !n.cfg.CompressionClientContextTakeover,
+		ServerMaxWindowBits:     offer.ServerMaxWindowBits,
+	}
+	n.accepted = &params
+	return params.Option(), nil
+}
+
+// permessage-deflate state of a connection
+type compression struct {
+	params    wsflate.Parameters
+	threshold int
+	level     int
+
+	// compressor of the outbound messages, guarded by the write lock of the client
+	writer *flate.Writer
+	output bytes.Buffer
+
+	// decompressor of the inbound messages and the window of the previous ones when the client keeps its
+	// context, only used by the goroutine reading the connection
+	reader io.ReadCloser
+	window []byte
+
+	// whether the message being read is compressed
+	state wsflate.MessageState
+}
+
+func newCompression(params wsflate.Parameters, cfg *Configuration) *compression {
+	return &compression{
+		params:    params,
+		threshold: cfg.CompressionThreshold,
+		level:     cfg.CompressionLevel,
+	}
+}
+
+// whether a message of the given size is worth compressing
+func (c *compression) shouldCompress(size int) bool {
+	return size > 0 && size >= c.threshold
+}
+
+// whether a message compressed once can be sent to every client not keeping the compression context
+func (c *compression) shareable() bool {
+	return c.params.ServerNoContextTakeover
+}
+
+// compresses the outbound message, the returned slice is only valid until the next call
+func (c *compression) compress(data []byte) ([]byte, error) {
+
+	c.output.Reset()
+
+	if c.writer == nil {
+		writer, err := flate.NewWriter(&c.output, c.level)
+		if err != nil {
+			return nil, err
+		}
+		c.writer = writer
+	} else if c.params.ServerNoContextTakeover {
+		c.writer.Reset(&c.output)
+	}
+
+	if _, err := c.writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(c.output.Bytes(), compressionTail), nil
+}
+
+// compresses the message without any context, so that it can be sent to any client not keeping one
+func compressMessage(data []byte, level int) ([]byte, error) {
+
+	var output bytes.Buffer
+	writer, err := flate.NewWriter(&output, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(output.Bytes(), compressionTail), nil
+}
+
+// decompresses the inbound message, using the previous messages as dictionary when the client keeps its context.
+// Decompression stops as soon as the message grows larger than the limit, when there is one
+func (c *compression) decompress(data []byte, limit int64) ([]byte, error) {
+
+	source := io.MultiReader(bytes.NewReader(data), bytes.NewReader(decompressionTail))
+
+	var dictionary []byte
+	if !c.params.ClientNoContextTakeover {
+		dictionary = c.window
+	}
+
+	if c.reader == nil {
+		c.reader = flate.NewReaderDict(source, dictionary)
+	} else if err := c.reader.(flate.Resetter).Reset(source, dictionary); err != nil {
+		return nil, err
+	}
+
+	message, err := readLimited(c.reader, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.params.ClientNoContextTakeover {
+		c.slideWindow(message)
+	}
+	return message, nil
+}
+
+// keeps the last compressionWindowSize bytes of the decompressed messages
+func (c *compression) slideWindow(message []byte) {
+
+	if len(message) >= compressionWindowSize {
+		c.window = append(c.window[:0], message[len(message)-compressionWindowSize:]...)
+		return
+	}
+
+	c.window = append(c.window, message...)
+	if excess := len(c.window) - compressionWindowSize; excess > 0 {
+		copy(c.window, c.window[excess:])
+		c.window = c.window[:compressionWindowSize]
+	}
+}
+
+// clears the compression bit of the inbound frames, the other reserved bits are defined by no extension
+func (c *compression) UnsetBits(header ws.Header) (ws.Header, error) {
+
+	header, err := c.state.UnsetBits(header)
+	if err == nil && header.Rsv != 0 {
+		err = ws.ErrProtocolNonZeroRsv
+	}
+	return header, err
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws/wsflate"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+
+	random := make([]byte, 3*compressionWindowSize)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name              string
+		noContextTakeover bool
+		messages          []string
+	}{
+		{"single message", false, []string{"hello hello hello hello"}},
+		{"messages sharing context", false, []string{"hello world", "hello world again", "hello world again and again"}},
+		{"messages without context", true, []string{"hello world", "hello world again", "hello world again and again"}},
+		{"messages larger than the window", false, []string{string(random), string(random[:100]), strings.Repeat("a", 2*compressionWindowSize)}},
+		{"single byte", false, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			params := wsflate.Parameters{ServerNoContextTakeover: tt.noContextTakeover, ClientNoContextTakeover: tt.noContextTakeover}
+			cfg := DefaultServerConfiguration(":0")
+			sender := newCompression(params, cfg)
+			receiver := newCompression(params, cfg)
+
+			for i, message := range tt.messages {
+				compressed, err := sender.compress([]byte(message))
+				if err != nil {
+					t.Fatalf("message %d: compress: %v", i, err)
+				}
+				if bytes.HasSuffix(compressed, compressionTail) {
+					t.Fatalf("message %d: compressed data ends with the sync flush tail", i)
+				}
+
+				decompressed, err := receiver.decompress(append([]byte(nil), compressed...), 0)
+				if err != nil {
+					t.Fatalf("message %d: decompress: %v", i, err)
+				}
+				if string(decompressed) != message {
+					t.Fatalf("message %d: got %d bytes back, want %d", i, len(decompressed), len(message))
+				}
+			}
+		})
+	}
+}
+
+func TestCompressMessageWithoutContext(t *testing.T) {
+
+	compressed, err := compressMessage([]byte("shared broadcast"), flate.BestSpeed)
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	// any client not keeping a context can read it, whatever it received before
+	for _, previous := range []string{"", "something else entirely"} {
+		receiver := newCompression(wsflate.Parameters{ClientNoContextTakeover: true}, DefaultServerConfiguration(":0"))
+		if previous != "" {
+			data, _ := compressMessage([]byte(previous), flate.BestSpeed)
+			if _, err := receiver.decompress(data, 0); err != nil {
+				t.Fatalf("decompress previous: %v", err)
+			}
+		}
+		decompressed, err := receiver.decompress(compressed, 0)
+		if err != nil || string(decompressed) != "shared broadcast" {
+			t.Fatalf("got %q, %v", decompressed, err)
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+
+	compressed, err := compressMessage(bytes.Repeat([]byte("a"), 1000), flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	receiver := newCompression(wsflate.Parameters{}, DefaultServerConfiguration(":0"))
+	if _, err := receiver.decompress(compressed, 999); err != ErrMessageTooLarge {
+		t.Fatalf("got %v, want %v", err, ErrMessageTooLarge)
+	}
+
+	receiver = newCompression(wsflate.Parameters{}, DefaultServerConfiguration(":0"))
+	if decompressed, err := receiver.decompress(compressed, 1000); err != nil || len(decompressed) != 1000 {
+		t.Fatalf("got %d bytes, %v, want 1000 bytes", len(decompressed), err)
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+
+	c := newCompression(wsflate.Parameters{}, DefaultServerConfiguration(":0").SetCompression(true, 10))
+
+	tests := []struct {
+		size int
+		want bool
+	}{
+		{0, false},
+		{9, false},
+		{10, true},
+		{1000, true},
+	}
+	for _, tt := range tests {
+		if got := c.shouldCompress(tt.size); got != tt.want {
+			t.Errorf("size %d: got %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		offers           string
+		serverTakeover   bool
+		clientTakeover   bool
+		accepted         bool
+		serverNoTakeover bool
+		clientNoTakeover bool
+	}{
+		{
+			name:           "plain offer",
+			offers:         "permessage-deflate",
+			serverTakeover: true,
+			clientTakeover: true,
+			accepted:       true,
+		},
+		{
+			name:             "client asks for no context",
+			offers:           "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+			serverTakeover:   true,
+			clientTakeover:   true,
+			accepted:         true,
+			serverNoTakeover: true,
+			clientNoTakeover: true,
+		},
+		{
+			name:             "server configured without context",
+			offers:           "permessage-deflate",
+			accepted:         true,
+			serverNoTakeover: true,
+			clientNoTakeover: true,
+		},
+		{
+			name:           "reduced server window declined",
+			offers:         "permessage-deflate; server_max_window_bits=10",
+			serverTakeover: true,
+			clientTakeover: true,
+		},
+		{
+			name:           "full server window accepted",
+			offers:         "permessage-deflate; server_max_window_bits=15",
+			serverTakeover: true,
+			clientTakeover: true,
+			accepted:       true,
+		},
+		{
+			name:             "malformed offer followed by a valid one",
+			offers:           "permessage-deflate; unknown_param, permessage-deflate; client_no_context_takeover",
+			serverTakeover:   true,
+			clientTakeover:   true,
+			accepted:         true,
+			clientNoTakeover: true,
+		},
+		{
+			name:           "other extension",
+			offers:         "x-webkit-deflate-frame",
+			serverTakeover: true,
+			clientTakeover: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cfg := DefaultServerConfiguration(":0").SetCompressionContextTakeover(tt.serverTakeover, tt.clientTakeover)
+			negotiation := &compressionNegotiation{cfg: cfg}
+
+			options, ok := httphead.ParseOptions([]byte(tt.offers), nil)
+			if !ok {
+				t.Fatalf("offers not parsed: %s", tt.offers)
+			}
+
+			answers := 0
+			for _, option := range options {
+				answer, err := negotiation.negotiate(option)
+				if err != nil {
+					t.Fatalf("negotiate: %v", err)
+				}
+				if answer.Size() > 0 {
+					answers++
+				}
+			}
+
+			if (negotiation.accepted != nil) != tt.accepted {
+				t.Fatalf("got accepted %v, want %v", negotiation.accepted != nil, tt.accepted)
+			}
+			if !tt.accepted {
+				if answers != 0 {
+					t.Fatalf("declined offers answered %d times", answers)
+				}
+				return
+			}
+			if answers != 1 {
+				t.Fatalf("got %d answers, want 1", answers)
+			}
+			if negotiation.accepted.ServerNoContextTakeover != tt.serverNoTakeover ||
+				negotiation.accepted.ClientNoContextTakeover != tt.clientNoTakeover {
+				t.Fatalf("got parameters %+v", *negotiation.accepted)
+			}
+		})
+	}
+}
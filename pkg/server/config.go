@@ -1,9 +1,16 @@
 package server
 
+import (
+	"compress/flate"
+	"crypto/tls"
+	"time"
+)
+
 const (
 
 	ENCODING_TYPE_JSON = "json"
 	ENCODING_TYPE_MSG_PACK = "msg_pack"
+	ENCODING_TYPE_PROTOBUF = "protobuf"
 
 )
 
@@ -16,23 +23,129 @@ type Configuration struct {
 	// host address to start the websocket server
 	HostAddr			string
 
+	// time given to an accepted connection to complete its TLS handshake and websocket upgrade before it is
+	// closed, 0 waits forever
+	HandshakeTimeout	time.Duration
+
+	// certificate and private key files to serve wss:// with, they are reloaded when rotated on disk
+	TLSCertFile			string
+	TLSKeyFile			string
+
+	// base TLS configuration of the listener. When set without certificate files it is used as is
+	TLSConfig			*tls.Config
+
+	// how often the certificate files are checked for rotation
+	TLSReloadInterval	time.Duration
+
+	// PEM bundle of the authorities client certificates are verified against
+	TLSClientCAFile		string
+
+	// policy for client certificates, requires TLSClientCAFile to verify them
+	TLSClientAuth		tls.ClientAuthType
+
+	// authenticate the clients presenting a verified certificate as soon as they connect, through the AuthHandler,
+	// instead of waiting for their authentication message
+	TLSClientCertAuth	bool
+
+	// time given to a client to authenticate after connecting before it is disconnected, 0 waits forever
+	AuthTimeout			time.Duration
+
+	// time ahead of the expiry of its authentication a client is sent the TokenExpiringEvent, 0 sends none
+	TokenExpiryWarning	time.Duration
+
+	// query parameter and header of the upgrade request a token is read from for the AuthHandler, empty to
+	// ignore them. A "Bearer " scheme is stripped from the header value
+	AuthTokenQueryParam	string
+	AuthTokenHeader		string
+
 	RedisHostAddr		string
 
+	// credentials and database of the redis server
+	RedisUsername		string
+	RedisPassword		string
+	RedisDB				int
+
+	// maximum number of socket connections to redis, 0 uses the redis client default
+	RedisPoolSize		int
+
+	// broker used to carry group broadcasts between the server nodes
+	BrokerType			BrokerType
+
+	// approximate number of entries retained per group stream when using redis streams, 0 keeps everything
+	RedisStreamMaxLength	int64
+
 	// configure the message format to use with the server
 	AcceptMessageEncoding Encoding
 
 	// configure this to receive server acknowledgment
 	SendAcknowledgement	bool
 
+	// maximum time given to handle a request before replying with a timeout error, also the default
+	// time a server initiated request waits for the reply of the client
+	RequestTimeout		time.Duration
+
+	// interval at which the clients are pinged, 0 disables pinging
+	PingInterval		time.Duration
+
+	// time a pinged client is given to show any sign of life before being disconnected
+	PongTimeout			time.Duration
+
+	// time after which a client which sent no message is disconnected even though it answers pings, 0 disables it
+	IdleTimeout			time.Duration
+
+	// maximum size in bytes of a message once reassembled and decompressed, a client sending a larger one is
+	// disconnected. 0 disables the limit
+	MaxMessageSize		int64
+
+	// maximum size in bytes of a single frame on the wire, 0 limits the frames to MaxMessageSize
+	MaxFrameSize		int64
+
+	// negotiate the permessage-deflate extension with the clients offering it
+	CompressionEnabled	bool
+
+	// messages smaller than this many bytes are sent uncompressed
+	CompressionThreshold	int
+
+	// compress/flate level of the outbound messages
+	CompressionLevel	int
+
+	// whether the server, respectively the clients, keep the compression context from one message to the next.
+	// Keeping it compresses repetitive messages better at the cost of a window of 32KB per connection and direction
+	CompressionServerContextTakeover	bool
+	CompressionClientContextTakeover	bool
+
 	// configure this for the max length of the broadcast channel
 	BroadcastMessagesLimit int64
 
-	// maximum number of parallel threads to execute simultaneously
+	// on linux, wait with epoll for the connections to be readable and read them on a pool of at most
+	// MaxThreadPoolConcurrency goroutines of its own, instead of blocking a goroutine per connection. TLS
+	// connections are still read by a goroutine of their own
+	NetpollEnabled		bool
+
+	// maximum number of parallel threads to execute simultaneously, the incoming messages and the callbacks
+	// they trigger are handled on a pool of at most this many goroutines
 	MaxThreadPoolConcurrency	int
 
 	// maximum number of work requests to be held onto the worker pool
 	RequestsBufferSize		int
 
+	// maximum number of messages of a single client waiting for its previous ones to be handled
+	ClientRequestsBufferSize	int
+
+	// what happens to an incoming message when every worker is busy and RequestsBufferSize messages are
+	// already waiting for one, or when ClientRequestsBufferSize messages of its client are already waiting
+	PoolFullPolicy			PoolFullPolicy
+
+	// time given to a message to be written to a client before the client is considered dead and disconnected,
+	// 0 waits forever
+	WriteTimeout			time.Duration
+
+	// maximum number of messages waiting to be written to a single client
+	OutboundQueueSize		int
+
+	// what happens to a message pushed to a client which already has OutboundQueueSize messages waiting
+	SlowConsumerPolicy		SlowConsumerPolicy
+
 	// log level for the entire application
 	LogLevel				int
 
@@ -45,25 +158,173 @@ func DefaultServerConfiguration(bindAddr string) *Configuration {
 	return &Configuration{
 		HostAddr:                 bindAddr,
 		RedisHostAddr: "localhost:6379",
+		HandshakeTimeout:         10 * time.Second,
+		TLSReloadInterval:        time.Minute,
+		AuthTimeout:              10 * time.Second,
+		TokenExpiryWarning:       time.Minute,
+		AuthTokenQueryParam:      "access_token",
+		AuthTokenHeader:          "Authorization",
+		BrokerType:               BROKER_TYPE_REDIS,
+		RedisStreamMaxLength:     10000,
 		SendAcknowledgement:      false,
+		RequestTimeout:           30 * time.Second,
+		PingInterval:             30 * time.Second,
+		PongTimeout:              10 * time.Second,
+		IdleTimeout:              0,
+		MaxMessageSize:           1 << 20,
+		MaxFrameSize:             0,
+		CompressionEnabled:       false,
+		CompressionThreshold:     512,
+		CompressionLevel:         flate.DefaultCompression,
+		CompressionServerContextTakeover: true,
+		CompressionClientContextTakeover: true,
 		AcceptMessageEncoding:    ENCODING_TYPE_JSON,
 		BroadcastMessagesLimit:   100,
+		NetpollEnabled:           false,
 		MaxThreadPoolConcurrency: 50000,
+		RequestsBufferSize:       10000,
+		ClientRequestsBufferSize: 1000,
+		PoolFullPolicy:           POOL_FULL_POLICY_BLOCK,
+		WriteTimeout:             10 * time.Second,
+		OutboundQueueSize:        256,
+		SlowConsumerPolicy:       SLOW_CONSUMER_POLICY_DISCONNECT,
 		LogLevel:                 ErrorLevel,
 		LoggerReportCaller:       false,
 	}
 }
 
+func (cfg *Configuration) SetTLSCertificate(certFile, keyFile string) *Configuration {
+	cfg.TLSCertFile = certFile
+	cfg.TLSKeyFile = keyFile
+	return cfg
+}
+
+func (cfg *Configuration) SetTLSConfig(tlsConfig *tls.Config) *Configuration {
+	cfg.TLSConfig = tlsConfig
+	return cfg
+}
+
+func (cfg *Configuration) SetTLSReloadInterval(interval time.Duration) *Configuration {
+	cfg.TLSReloadInterval = interval
+	return cfg
+}
+
+func (cfg *Configuration) SetTLSClientAuth(caFile string, clientAuth tls.ClientAuthType) *Configuration {
+	cfg.TLSClientCAFile = caFile
+	cfg.TLSClientAuth = clientAuth
+	return cfg
+}
+
+func (cfg *Configuration) SetTLSClientCertAuth(flag bool) *Configuration {
+	cfg.TLSClientCertAuth = flag
+	return cfg
+}
+
+func (cfg *Configuration) SetHandshakeTimeout(timeout time.Duration) *Configuration {
+	cfg.HandshakeTimeout = timeout
+	return cfg
+}
+
+func (cfg *Configuration) SetAuthTimeout(timeout time.Duration) *Configuration {
+	cfg.AuthTimeout = timeout
+	return cfg
+}
+
+func (cfg *Configuration) SetTokenExpiryWarning(warning time.Duration) *Configuration {
+	cfg.TokenExpiryWarning = warning
+	return cfg
+}
+
+func (cfg *Configuration) SetAuthTokenSources(queryParam, header string) *Configuration {
+	cfg.AuthTokenQueryParam = queryParam
+	cfg.AuthTokenHeader = header
+	return cfg
+}
+
+// whether the listener serves wss:// rather than ws://
+func (cfg *Configuration) TLSEnabled() bool {
+	return cfg.TLSConfig != nil || (cfg.TLSCertFile != "" && cfg.TLSKeyFile != "")
+}
+
 func (cfg *Configuration) SetRedisHostAddr(host string) *Configuration {
 	cfg.RedisHostAddr = host
 	return cfg
 }
 
+func (cfg *Configuration) SetRedisCredentials(username, password string) *Configuration {
+	cfg.RedisUsername = username
+	cfg.RedisPassword = password
+	return cfg
+}
+
+func (cfg *Configuration) SetRedisDB(db int) *Configuration {
+	cfg.RedisDB = db
+	return cfg
+}
+
+func (cfg *Configuration) SetRedisPoolSize(size int) *Configuration {
+	cfg.RedisPoolSize = size
+	return cfg
+}
+
+func (cfg *Configuration) SetBrokerType(brokerType BrokerType) *Configuration {
+	cfg.BrokerType = brokerType
+	return cfg
+}
+
+func (cfg *Configuration) SetRedisStreamMaxLength(length int64) *Configuration {
+	cfg.RedisStreamMaxLength = length
+	return cfg
+}
+
 func (cfg *Configuration) SetSendAcknowledgment(flag bool) *Configuration {
 	cfg.SendAcknowledgement = flag
 	return cfg
 }
 
+func (cfg *Configuration) SetRequestTimeout(timeout time.Duration) *Configuration {
+	cfg.RequestTimeout = timeout
+	return cfg
+}
+
+func (cfg *Configuration) SetHeartbeat(pingInterval, pongTimeout time.Duration) *Configuration {
+	cfg.PingInterval = pingInterval
+	cfg.PongTimeout = pongTimeout
+	return cfg
+}
+
+func (cfg *Configuration) SetIdleTimeout(timeout time.Duration) *Configuration {
+	cfg.IdleTimeout = timeout
+	return cfg
+}
+
+func (cfg *Configuration) SetMaxMessageSize(size int64) *Configuration {
+	cfg.MaxMessageSize = size
+	return cfg
+}
+
+func (cfg *Configuration) SetMaxFrameSize(size int64) *Configuration {
+	cfg.MaxFrameSize = size
+	return cfg
+}
+
+func (cfg *Configuration) SetCompression(enabled bool, threshold int) *Configuration {
+	cfg.CompressionEnabled = enabled
+	cfg.CompressionThreshold = threshold
+	return cfg
+}
+
+func (cfg *Configuration) SetCompressionLevel(level int) *Configuration {
+	cfg.CompressionLevel = level
+	return cfg
+}
+
+func (cfg *Configuration) SetCompressionContextTakeover(server, client bool) *Configuration {
+	cfg.CompressionServerContextTakeover = server
+	cfg.CompressionClientContextTakeover = client
+	return cfg
+}
+
 func (cfg *Configuration) SetAcceptMessageEncoding(encoding Encoding) *Configuration {
 	cfg.AcceptMessageEncoding = encoding
 	return cfg
@@ -74,11 +335,46 @@ func (cfg *Configuration) SetLogLevel(logLevel int) *Configuration {
 	return cfg
 }
 
+func (cfg *Configuration) SetNetpoll(flag bool) *Configuration {
+	cfg.NetpollEnabled = flag
+	return cfg
+}
+
 func (cfg *Configuration) SetMaxThreadPoolConcurrency(concurrency int) *Configuration {
 	cfg.MaxThreadPoolConcurrency = concurrency
 	return cfg
 }
 
+func (cfg *Configuration) SetRequestsBufferSize(size int) *Configuration {
+	cfg.RequestsBufferSize = size
+	return cfg
+}
+
+func (cfg *Configuration) SetClientRequestsBufferSize(size int) *Configuration {
+	cfg.ClientRequestsBufferSize = size
+	return cfg
+}
+
+func (cfg *Configuration) SetPoolFullPolicy(policy PoolFullPolicy) *Configuration {
+	cfg.PoolFullPolicy = policy
+	return cfg
+}
+
+func (cfg *Configuration) SetWriteTimeout(timeout time.Duration) *Configuration {
+	cfg.WriteTimeout = timeout
+	return cfg
+}
+
+func (cfg *Configuration) SetOutboundQueueSize(size int) *Configuration {
+	cfg.OutboundQueueSize = size
+	return cfg
+}
+
+func (cfg *Configuration) SetSlowConsumerPolicy(policy SlowConsumerPolicy) *Configuration {
+	cfg.SlowConsumerPolicy = policy
+	return cfg
+}
+
 func (cfg *Configuration) SetBroadcastMessagesLimit(limit int64) *Configuration {
 	cfg.BroadcastMessagesLimit = limit
 	return cfg
@@ -4,4 +4,13 @@ const (
 
 	AuthenticationEvent = "authenticate"
 
+	// event warning the client that its authentication is about to expire, so that it authenticates again
+	TokenExpiringEvent = "token_expiring"
+
+	// event under which a failed event handling is reported back to the client
+	ErrorEvent = "error"
+
+	// event acknowledging the receipt of a message when acknowledgements are enabled
+	AcknowledgementEvent = "ack"
+
 )
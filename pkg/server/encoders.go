@@ -0,0 +1,27 @@
+package server
+
+// registers the encoder for the encoding, clients pick the encoding of their connection by offering its name
+// in the Sec-WebSocket-Protocol header of the upgrade request
+func (ss *SocketServer) RegisterEncoder(encoding Encoding, encoder IEncoder) {
+	ss.Lock()
+	ss.encoders[encoding] = encoder
+	ss.Unlock()
+}
+
+// finds the encoder registered for the encoding
+func (ss *SocketServer) getEncoder(encoding Encoding) (IEncoder, bool) {
+	ss.RLock()
+	encoder, ok := ss.encoders[encoding]
+	ss.RUnlock()
+
+	return encoder, ok
+}
+
+// encoder of a connection whose negotiated subprotocol is given, falling back to AcceptMessageEncoding
+// when the client did not offer any registered encoding
+func (ss *SocketServer) negotiatedEncoder(protocol string) IEncoder {
+	if encoder, ok := ss.getEncoder(Encoding(protocol)); ok {
+		return encoder
+	}
+	return ss.encoder
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// a client speaking the encoding it negotiated
+type encodedClient struct {
+	conn    net.Conn
+	encoder IEncoder
+}
+
+func dialEncoded(t *testing.T, url string, protocols []string, encoder IEncoder, wantProtocol string) *encodedClient {
+
+	conn, _, handshake, err := ws.Dialer{Protocols: protocols}.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if handshake.Protocol != wantProtocol {
+		t.Fatalf("offered %v, got protocol %q, want %q", protocols, handshake.Protocol, wantProtocol)
+	}
+
+	client := &encodedClient{conn: conn, encoder: encoder}
+	client.send(t, Message{Event: AuthenticationEvent, Payload: map[string]interface{}{}})
+	return client
+}
+
+func (ec *encodedClient) send(t *testing.T, msg Message) {
+	if err := wsutil.WriteClientMessage(ec.conn, ec.encoder.OpCode(), ec.encoder.Encode(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reads the next message, which must come in the frames of the negotiated encoding
+func (ec *encodedClient) receive(t *testing.T) *Message {
+
+	ec.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, opCode, err := wsutil.ReadServerData(ec.conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if opCode != ec.encoder.OpCode() {
+		t.Fatalf("got op code %v, want %v", opCode, ec.encoder.OpCode())
+	}
+	msg, err := ec.encoder.Decode(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return msg
+}
+
+func TestEncodingNegotiation(t *testing.T) {
+
+	cfg := DefaultServerConfiguration(":0").SetSendAcknowledgment(false)
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+	ss.On("echo", func(ctx *EventContext) error {
+		return ctx.Reply(ctx.Payload())
+	})
+	ss.On("shout", func(ctx *EventContext) error {
+		ctx.BroadcastToGroup("shouted", ctx.Payload())
+		return nil
+	})
+
+	srv := httptest.NewServer(ss)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		name      string
+		protocols []string
+		protocol  string
+		encoder   IEncoder
+	}{
+		{"no protocol offered", nil, "", &JsonEncoder{}},
+		{"json", []string{ENCODING_TYPE_JSON}, ENCODING_TYPE_JSON, &JsonEncoder{}},
+		{"msgpack", []string{ENCODING_TYPE_MSG_PACK}, ENCODING_TYPE_MSG_PACK, &MsgPackEncoder{}},
+		{"protobuf", []string{ENCODING_TYPE_PROTOBUF}, ENCODING_TYPE_PROTOBUF, &ProtobufEncoder{}},
+		{"unknown protocol first", []string{"xml", ENCODING_TYPE_MSG_PACK}, ENCODING_TYPE_MSG_PACK, &MsgPackEncoder{}},
+		{"unknown protocol only", []string{"xml"}, "", &JsonEncoder{}},
+	}
+
+	// the clients stay connected for the broadcast, hence no subtests closing them
+	clients := make([]*encodedClient, 0, len(tests))
+	for _, tt := range tests {
+		client := dialEncoded(t, url, tt.protocols, tt.encoder, tt.protocol)
+		client.send(t, Message{Event: "echo", Id: "1", Payload: map[string]interface{}{"text": tt.name}})
+
+		reply := client.receive(t)
+		if reply.Event != "echo" || reply.ReplyTo != "1" || reply.Payload["text"] != tt.name {
+			t.Fatalf("%s: got %s replying to %s: %v", tt.name, reply.Event, reply.ReplyTo, reply.Payload)
+		}
+		clients = append(clients, client)
+	}
+
+	// a broadcast reaches every client of the group in its own encoding
+	clients[0].send(t, Message{Event: "shout", Payload: map[string]interface{}{"text": "everyone"}})
+	for i, client := range clients {
+		msg := client.receive(t)
+		if msg.Event != "shouted" || msg.Payload["text"] != "everyone" {
+			t.Fatalf("client %s: got %s %v", tests[i].name, msg.Event, msg.Payload)
+		}
+	}
+}
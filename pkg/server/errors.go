@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+
+	ErrCodeInternal = "internal_error"
+	ErrCodeUnauthorized = "unauthorized"
+	ErrCodeInvalidPayload = "invalid_payload"
+	ErrCodeRateLimited = "rate_limited"
+	ErrCodeTimeout = "timeout"
+	ErrCodeOverloaded = "overloaded"
+	ErrCodeForbidden = "forbidden"
+
+)
+
+var (
+
+	// returned when replying to a request which already got its reply or timed out
+	ErrAlreadyReplied = errors.New("request has already been replied to")
+
+	// returned when sending a request to a client which is not connected to this node
+	ErrClientNotFound = errors.New("client not found")
+
+	// returned when a message could not be encoded for the wire
+	ErrEncodingFailed = errors.New("message could not be encoded")
+
+	// reported on disconnection of a client which stopped answering pings
+	ErrHeartbeatTimeout = errors.New("client did not answer pings in time")
+
+	// reported on disconnection of a client which sent no message for too long
+	ErrIdleTimeout = errors.New("client has been idle for too long")
+
+	// reported on disconnection of a client which sent a message larger than MaxMessageSize
+	ErrMessageTooLarge = errors.New("message exceeds the maximum message size")
+
+	// reported on disconnection of a client which sent a frame larger than MaxFrameSize
+	ErrFrameTooLarge = errors.New("frame exceeds the maximum frame size")
+
+	// returned when a message is discarded because the client does not read its messages fast enough, and
+	// reported on disconnection of such a client
+	ErrSlowConsumer = errors.New("client does not keep up with its messages")
+
+	// reported on disconnection of a client the AuthHandler rejected
+	ErrAuthenticationFailed = errors.New("client failed to authenticate")
+
+	// reported on disconnection of a client which sent another event before the authentication event
+	ErrAuthenticationRequired = errors.New("client sent an event before authenticating")
+
+	// reported on disconnection of a client which did not authenticate within AuthTimeout
+	ErrAuthenticationTimeout = errors.New("client did not authenticate in time")
+
+	// reported on disconnection of a client whose authentication expired without being refreshed
+	ErrAuthenticationExpired = errors.New("client authentication expired")
+
+	// reported on disconnection of a client the Authorizer did not allow to join its group
+	ErrAuthorizationDenied = errors.New("client is not allowed to join the group")
+
+	// returned when pushing a message to a client whose connection is closed, or when a client disconnects
+	// before replying to a request of the server
+	ErrClientClosed = errors.New("client connection is closed")
+
+)
+
+// error returned by an EventHandler or a Middleware which is sent back to the client as an error frame
+type HandlerError struct {
+	Code	string
+	Message	string
+}
+
+func NewHandlerError(code, message string) *HandlerError {
+	return &HandlerError{Code: code, Message: message}
+}
+
+func (he *HandlerError) Error() string {
+	return fmt.Sprintf("%s: %s", he.Code, he.Message)
+}
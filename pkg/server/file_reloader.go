@@ -0,0 +1,110 @@
+package server
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// value loaded from a set of files and loaded again once any of them changes on disk, so that rotated
+// credentials are picked up without restarting the server. The files are checked at most once per
+// checkInterval, and a value which fails to load keeps the previous one served
+type fileReloader struct {
+	sync.Mutex
+
+	files			[]string
+
+	// what is loaded, as named in the logs
+	description		string
+	load			func() (interface{}, error)
+
+	// how often the files are checked for modification
+	checkInterval	time.Duration
+	lastCheck		time.Time
+
+	value			interface{}
+	modTimes		[]time.Time
+}
+
+func newFileReloader(description string, checkInterval time.Duration, load func() (interface{}, error), files ...string) (*fileReloader, error) {
+
+	fr := &fileReloader{
+		files:         files,
+		description:   description,
+		load:          load,
+		checkInterval: checkInterval,
+	}
+
+	if err := fr.reload(); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
+
+// modification times of the files
+func (fr *fileReloader) stat() ([]time.Time, error) {
+
+	modTimes := make([]time.Time, len(fr.files))
+	for i, file := range fr.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// loads the value from disk
+func (fr *fileReloader) reload() error {
+
+	modTimes, err := fr.stat()
+	if err != nil {
+		return err
+	}
+
+	value, err := fr.load()
+	if err != nil {
+		return err
+	}
+
+	fr.value = value
+	fr.modTimes = modTimes
+	fr.lastCheck = time.Now()
+	return nil
+}
+
+// whether any of the files has been modified since the value was loaded
+func (fr *fileReloader) modified() bool {
+
+	modTimes, err := fr.stat()
+	if err != nil {
+		return false
+	}
+	for i, modTime := range modTimes {
+		if !modTime.Equal(fr.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// the value, loaded again first when the files have been modified since the last check
+func (fr *fileReloader) current() interface{} {
+
+	fr.Lock()
+	defer fr.Unlock()
+
+	if time.Since(fr.lastCheck) >= fr.checkInterval {
+		fr.lastCheck = time.Now()
+
+		if fr.modified() {
+			if err := fr.reload(); err != nil {
+				AppLogger.Errorf("[current] error occurred while reloading %s, serving the previous one: %v", fr.description, err)
+			} else {
+				AppLogger.Infof("[current] %s reloaded from: %s", fr.description, fr.files[0])
+			}
+		}
+	}
+	return fr.value
+}
@@ -2,7 +2,7 @@ package server
 
 import (
 	"context"
-	"github.com/go-redis/redis/v8"
+	"encoding/json"
 	"github.com/gobwas/ws"
 	"sync"
 )
@@ -20,10 +20,16 @@ type group struct {
 	// holds a connection to a client which is just connected and has not yet authenticated
 	clients 			map[string]*socketClient
 
-	pubsubChannel		*redis.PubSub
+	// identifier of the server node owning this group, used to drop our own messages coming back from the broker
+	nodeId				string
 
-	// a common channel every socketClient listens to for a common broadcast functionality
-	broadcastChannel	chan interface{}
+	broker				Broker
+
+	// broadcasts published by every node on the group channel of the broker
+	subscription		<-chan []byte
+
+	// broadcasts to deliver to the clients of the group connected to this node
+	broadcastChannel	chan *Message
 
 	// channel to safely close all the connected clients and the group channels properly
 	shutdownChannel		chan interface{}
@@ -32,13 +38,54 @@ type group struct {
 	downChannel		chan interface{}
 }
 
+// prefix of the broker channels carrying the broadcasts of the groups, keeping them apart from the channels
+// the server uses for itself whatever id a group is given
+const groupChannelPrefix = "brisk:group:"
+
+// broker channel of the group
+func groupChannel(groupId string) string {
+	return groupChannelPrefix + groupId
+}
+
+// envelope in which a broadcast travels over the broker between server nodes. The message travels
+// as JSON whatever the encodings of the clients, every node encoding it for its own clients
+type broadcastEnvelope struct {
+	Origin	string		`json:"origin"`
+	Message	*Message	`json:"message"`
+
+	// raw payload of the message, see detachRawPayload
+	Raw		[]byte		`json:"raw"`
+}
+
+// takes the raw payload out of a copy of the message so that it travels next to it. Within the message JSON
+// would turn the bytes into a base64 string, delivered as such to the clients of the other nodes
+func detachRawPayload(msg *Message) (*Message, []byte) {
+
+	raw, ok := rawPayload(msg.Payload)
+	if !ok {
+		return msg, nil
+	}
+	detached := *msg
+	detached.Payload = nil
+	return &detached, raw
+}
+
+// puts back the raw payload taken out of the message by detachRawPayload
+func attachRawPayload(msg *Message, raw []byte) {
+	if msg != nil && raw != nil {
+		msg.Payload = map[string]interface{}{RawPayloadKey: raw}
+	}
+}
+
 // creates a new instance of hub with a max buffer size of broadcast channel passed as parameters
-func newGroup(groupId string, broadcastChannelCap int64) *group {
+func newGroup(groupId string, broadcastChannelCap int64, nodeId string, broker Broker) *group {
 
 	g := &group{
 		Id: groupId,
+		nodeId: nodeId,
+		broker: broker,
 		clients:    make(map[string]*socketClient),
-		broadcastChannel: make(chan interface{}, broadcastChannelCap),
+		broadcastChannel: make(chan *Message, broadcastChannelCap),
 		shutdownChannel: make(chan interface{}),
 		downChannel: make(chan interface{}, broadcastChannelCap),
 	}
@@ -65,6 +112,7 @@ func (g *group) getClient(id string) *socketClient {
 // adds a client to the common clients map since it is new and not yet authenticated
 func (g *group) addClient(client *socketClient) {
 	g.Lock()
+	client.group = g
 	g.clients[client.Id] = client
 	g.Unlock()
 }
@@ -72,22 +120,24 @@ func (g *group) addClient(client *socketClient) {
 // finds the client from the clientHub, closes the socket connection and then removes it from clientHub
 func (g *group) removeClient(id string, callbackFunc func(clientId string, err error)) {
 
-	var client *socketClient = nil
-	var err error = nil
-
 	g.Lock()
-	if val, ok := g.clients[id]; ok {
-		client = val
-		if err = val.StopClient(); err != nil {
-			AppLogger.Errorf("[removeClient] client: %v: %v", val.Id, err)
-		} else {
-			AppLogger.Infof("[removeClient] client: %s closed\n", id)
-		}
-		delete(g.clients, val.Id)
-	}
+	client, ok := g.clients[id]
+	delete(g.clients, id)
 	g.Unlock()
 
-	if callbackFunc != nil && client != nil {
+	if !ok {
+		return
+	}
+
+	// closing outside of the lock, writing the close frame may take a while with a slow peer
+	err := client.StopClient()
+	if err != nil {
+		AppLogger.Errorf("[removeClient] client: %v: %v", client.Id, err)
+	} else {
+		AppLogger.Infof("[removeClient] client: %s closed\n", id)
+	}
+
+	if callbackFunc != nil {
 		callbackFunc(client.Id, err)
 	}
 }
@@ -116,13 +166,26 @@ func (g *group) broadcastReceiver() {
 	go func() {
 		for {
 			select {
-			case incomingBroadcast:= <- g.broadcastChannel:
+			case msg := <-g.broadcastChannel:
 
-				dataStr := incomingBroadcast.(string)
+				// clients sharing an encoder share the message encoded and framed once
+				prepared := make(map[IEncoder]*preparedMessage)
 
 				g.RLock()
-				for key, _ := range g.clients {
-					g.clients[key].PushData([]byte(dataStr), ws.OpText)
+				for key, client := range g.clients {
+
+					pm, ok := prepared[client.encoder]
+					if !ok {
+						pm = g.prepare(msg, client.encoder)
+						prepared[client.encoder] = pm
+					}
+					if pm == nil {
+						continue
+					}
+
+					if err := client.pushPrepared(pm); err != nil {
+						AppLogger.Errorf("[broadcastReceiver] error occurred while pushing data to client: %s: %v", key, err)
+					}
 				}
 				g.RUnlock()
 
@@ -134,18 +197,85 @@ func (g *group) broadcastReceiver() {
 	}()
 }
 
-func (g *group) createPubSubConnection(channelName string) {
+// encodes and frames the broadcast for the clients of the encoder, nil when it can't be encoded
+func (g *group) prepare(msg *Message, encoder IEncoder) *preparedMessage {
 
-	if g.pubsubChannel == nil {
-		g.pubsubChannel = RedisClient.Subscribe(context.Background(), channelName)
+	data := encoder.Encode(*msg)
+	if data == nil {
+		AppLogger.Errorf("[prepare] group: %s error occurred while encoding broadcast: %v", g.Id, ErrEncodingFailed)
+		return nil
+	}
 
-		if err := g.pubsubChannel.Subscribe(context.Background(), channelName); err != nil {
-			AppLogger.Errorf("[createPubSubConnection] err: %v", err)
-		}
+	pm, err := newPreparedMessage(data, encoder.OpCode())
+	if err != nil {
+		AppLogger.Errorf("[prepare] group: %s error occurred while framing broadcast: %v", g.Id, err)
+		return nil
 	}
+	return pm
 }
 
+// subscribes the group to its channel on the broker
+func (g *group) subscribe() {
+
+	if g.subscription != nil {
+		return
+	}
 
-func (g *group) createBroadcast(msg interface{}) {
+	subscription, err := g.broker.Subscribe(context.Background(), groupChannel(g.Id))
+	if err != nil {
+		AppLogger.Errorf("[subscribe] group: %s err: %v", g.Id, err)
+		return
+	}
+	g.subscription = subscription
+}
+
+// consumes the broker subscription of the group and fans out broadcasts published by other nodes to local clients
+func (g *group) subscriptionReceiver() {
+
+	if g.subscription == nil {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case data, ok := <-g.subscription:
+				if !ok {
+					AppLogger.Infoln("[subscriptionReceiver] subscription closed")
+					return
+				}
+
+				var envelope broadcastEnvelope
+				if err := json.Unmarshal(data, &envelope); err != nil {
+					AppLogger.Errorf("[subscriptionReceiver] group: %s error occurred while decoding broadcast: %v", g.Id, err)
+					continue
+				}
+
+				// this node already delivered the message to its own clients while publishing it
+				if envelope.Origin == g.nodeId {
+					continue
+				}
+				attachRawPayload(envelope.Message, envelope.Raw)
+				g.createBroadcast(envelope.Message)
+
+			case <-g.shutdownChannel:
+				AppLogger.Infoln("[subscriptionReceiver] shutting down")
+				return
+			}
+		}
+	}()
+}
+
+func (g *group) createBroadcast(msg *Message) {
 	g.broadcastChannel <- msg
-}
\ No newline at end of file
+}
+
+// stops the broadcast receivers of the group and cancels its subscription to the broker
+func (g *group) shutdown(ctx context.Context) {
+
+	close(g.shutdownChannel)
+
+	if err := g.broker.Unsubscribe(ctx, groupChannel(g.Id)); err != nil {
+		AppLogger.Errorf("[shutdown] group: %s error occurred while unsubscribing: %v", g.Id, err)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBroadcastEnvelopeKeepsRawPayload(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+	}{
+		{"raw bytes", map[string]interface{}{RawPayloadKey: []byte{0, 1, 255}}},
+		{"empty raw bytes", map[string]interface{}{RawPayloadKey: []byte{}}},
+		{"map payload", map[string]interface{}{"text": "hi"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			original := &Message{Event: "event", Payload: tt.payload}
+			msg, raw := detachRawPayload(original)
+			data, err := json.Marshal(broadcastEnvelope{Origin: "node", Message: msg, Raw: raw})
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if original.Payload == nil {
+				t.Fatal("the payload of the original message was taken out")
+			}
+
+			var envelope broadcastEnvelope
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			attachRawPayload(envelope.Message, envelope.Raw)
+
+			if want, ok := tt.payload[RawPayloadKey].([]byte); ok {
+				got, isBytes := envelope.Message.Payload[RawPayloadKey].([]byte)
+				if !isBytes || !bytes.Equal(got, want) {
+					t.Fatalf("got raw payload %#v, want %v", envelope.Message.Payload[RawPayloadKey], want)
+				}
+				return
+			}
+			if envelope.Message.Payload["text"] != "hi" {
+				t.Fatalf("got payload %#v", envelope.Message.Payload)
+			}
+		})
+	}
+}
+
+func TestGroupChannelsApartFromUsersChannel(t *testing.T) {
+
+	cfg := DefaultServerConfiguration(":0")
+	cfg.PingInterval = 0
+	broker := NewMemoryBroker(10)
+	ss := NewSocketServer(cfg, broker)
+	defer ss.Shutdown(context.Background())
+
+	// another node listening on both channels
+	other := broker.Connect()
+	users, err := other.Subscribe(context.Background(), usersChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := other.Subscribe(context.Background(), groupChannel(usersChannel))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a group named after the users channel only broadcasts on its own channel
+	ss.AddGroup(usersChannel, 10)
+	ss.BroadcastToGroup(usersChannel, &Message{Event: "event", Payload: map[string]interface{}{"text": "hi"}})
+
+	select {
+	case data := <-groups:
+		var envelope broadcastEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil || envelope.Message.Event != "event" {
+			t.Fatalf("got broadcast %s, %v", data, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast not published on the group channel")
+	}
+
+	select {
+	case data := <-users:
+		t.Fatalf("broadcast published on the users channel: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
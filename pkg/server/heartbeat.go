@@ -0,0 +1,114 @@
+package server
+
+import (
+	"github.com/gobwas/ws"
+	"sync/atomic"
+	"time"
+)
+
+// starts the single goroutine pinging every client of the node and reaping the silent and idle ones
+func (ss *SocketServer) startHeartbeat() {
+
+	tick := ss.heartbeatTick()
+	if tick <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				ss.heartbeat(now)
+			case <-ss.stopHeartbeat:
+				return
+			}
+		}
+	}()
+}
+
+// half of the shortest configured delay, so that none of them is overshot by more than half of it
+func (ss *SocketServer) heartbeatTick() time.Duration {
+
+	var shortest time.Duration
+	for _, delay := range []time.Duration{ss.PingInterval, ss.PongTimeout, ss.IdleTimeout} {
+		if delay > 0 && (shortest == 0 || delay < shortest) {
+			shortest = delay
+		}
+	}
+	if ss.PingInterval <= 0 && ss.IdleTimeout <= 0 {
+		return 0
+	}
+	return shortest / 2
+}
+
+func (ss *SocketServer) heartbeat(now time.Time) {
+
+	ss.clientsLock.RLock()
+	clients := make([]*socketClient, 0, len(ss.clients))
+	for _, client := range ss.clients {
+		clients = append(clients, client)
+	}
+	ss.clientsLock.RUnlock()
+
+	for _, client := range clients {
+
+		lastSeen := time.Unix(0, atomic.LoadInt64(&client.lastSeen))
+		lastActive := time.Unix(0, atomic.LoadInt64(&client.lastActive))
+		lastPing := time.Unix(0, atomic.LoadInt64(&client.lastPing))
+
+		if ss.PingInterval > 0 && now.Sub(lastSeen) > ss.PingInterval+ss.PongTimeout {
+			AppLogger.Infof("[heartbeat] client: %s stopped answering pings, disconnecting", client.Id)
+			ss.reapClient(client, ws.StatusGoingAway, "ping timeout", ErrHeartbeatTimeout)
+			continue
+		}
+
+		if ss.IdleTimeout > 0 && now.Sub(lastActive) > ss.IdleTimeout {
+			AppLogger.Infof("[heartbeat] client: %s idle for too long, disconnecting", client.Id)
+			ss.reapClient(client, ws.StatusGoingAway, "idle timeout", ErrIdleTimeout)
+			continue
+		}
+
+		if ss.PingInterval > 0 && now.Sub(lastPing) >= ss.PingInterval {
+			atomic.StoreInt64(&client.lastPing, now.UnixNano())
+
+			// pinging in the background so that a peer which stopped reading does not hold back the others
+			go func(client *socketClient) {
+				if err := client.pushControl(ws.OpPing, nil, ss.pingWriteTimeout()); err != nil {
+					AppLogger.Debugf("[heartbeat] client: %s error occurred while sending ping: %v", client.Id, err)
+				}
+			}(client)
+		}
+	}
+}
+
+// time given to a ping to be written, a client which cannot take it in that time is considered dead
+func (ss *SocketServer) pingWriteTimeout() time.Duration {
+	if ss.PongTimeout > 0 {
+		return ss.PongTimeout
+	}
+	return ss.PingInterval
+}
+
+// closes the connection of the client with the status code and removes it, reporting the reason.
+// Done in the background as writing the close frame to a dead peer takes until its write timeout
+func (ss *SocketServer) reapClient(client *socketClient, code ws.StatusCode, reason string, err error) {
+
+	if !atomic.CompareAndSwapInt32(&client.reaped, 0, 1) {
+		return
+	}
+
+	// nothing the client sends is handled anymore
+	client.markClosing()
+
+	client.setCloseReason(err)
+
+	go func() {
+		if closeErr := client.closeWithStatus(code, reason); closeErr != nil {
+			AppLogger.Debugf("[reapClient] client: %s error occurred while closing connection: %v", client.Id, closeErr)
+		}
+		ss.removeClient(client, err)
+	}()
+}
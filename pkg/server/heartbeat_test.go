@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestHeartbeat(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		pingInterval time.Duration
+		idleTimeout  time.Duration
+		answerPings  bool
+		deadline     time.Duration
+		reason       string
+		err          error
+	}{
+		{"silent peer", 100 * time.Millisecond, 0, false, 200 * time.Millisecond, "ping timeout", ErrHeartbeatTimeout},
+		{"peer answering pings", 100 * time.Millisecond, 0, true, 0, "", nil},
+		{"idle peer answering pings", 100 * time.Millisecond, 300 * time.Millisecond, true, 300 * time.Millisecond, "idle timeout", ErrIdleTimeout},
+		{"idle peer without pings", 0, 300 * time.Millisecond, false, 300 * time.Millisecond, "idle timeout", ErrIdleTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cfg := DefaultServerConfiguration(":0").SetHeartbeat(tt.pingInterval, 100*time.Millisecond).SetIdleTimeout(tt.idleTimeout)
+			ss := NewSocketServer(cfg, NewMemoryBroker(10))
+			disconnected := make(chan error, 1)
+			ss.OnClientDisconnected = func(clientId string, err error) { disconnected <- err }
+
+			srv := httptest.NewServer(ss)
+			defer srv.Close()
+			conn := dialTestClient(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+			start := time.Now()
+
+			// reading answers the pings of the server, a peer which does not read leaves them unanswered
+			closed := make(chan wsutil.ClosedError, 1)
+			read := func() {
+				for {
+					_, err := wsutil.ReadServerText(conn)
+					var closedErr wsutil.ClosedError
+					if errors.As(err, &closedErr) {
+						closed <- closedErr
+						return
+					}
+					if err != nil {
+						return
+					}
+				}
+			}
+			if tt.answerPings {
+				go read()
+			}
+
+			select {
+			case err := <-disconnected:
+				if tt.err == nil {
+					t.Fatalf("peer disconnected with %v", err)
+				}
+				if err != tt.err {
+					t.Fatalf("disconnected with %v, want %v", err, tt.err)
+				}
+				if elapsed := time.Since(start); elapsed < tt.deadline {
+					t.Fatalf("disconnected after %v, before %v", elapsed, tt.deadline)
+				}
+			case <-time.After(time.Second):
+				if tt.err != nil {
+					t.Fatal("peer not disconnected")
+				}
+				return
+			}
+
+			if !tt.answerPings {
+				// the pings left in the socket are skipped, answering them would write to a closed connection
+				go func() {
+					for {
+						frame, err := ws.ReadFrame(conn)
+						if err != nil {
+							return
+						}
+						if frame.Header.OpCode == ws.OpClose {
+							code, reason := ws.ParseCloseFrameData(ws.UnmaskFrameInPlace(frame).Payload)
+							closed <- wsutil.ClosedError{Code: code, Reason: reason}
+							return
+						}
+					}
+				}()
+			}
+			select {
+			case closedErr := <-closed:
+				if closedErr.Code != ws.StatusGoingAway || closedErr.Reason != tt.reason {
+					t.Fatalf("closed with %d %q, want %d %q", closedErr.Code, closedErr.Reason, ws.StatusGoingAway, tt.reason)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("close frame not received")
+			}
+		})
+	}
+}
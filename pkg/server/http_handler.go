@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/gobwas/ws"
+	"net"
+	"net/http"
+	"net/textproto"
+)
+
+// serves the websocket upgrade requests of an existing net/http server, so that the socket server can be
+// mounted on a path of it instead of listening on HostAddr:
+//
+//	mux.Handle("/ws", socketServer)
+//
+// The upgraded connections go through the same callbacks, authentication and groups as the ones
+// accepted by StartListening
+func (ss *SocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if ss.isShuttingDown() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	header, err := ss.runUpgradeCallbacks(r)
+	if err != nil {
+		AppLogger.Errorln("[ServeHTTP] upgrade request rejected by callbacks: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	negotiation := &compressionNegotiation{cfg: ss.Configuration}
+	capture := &tokenCapture{cfg: ss.Configuration}
+	capture.fromRequest(r)
+
+	upgrader := ws.HTTPUpgrader{
+		Header: header,
+		// the first encoding offered by the client which has an encoder registered is selected
+		Protocol: func(protocol string) bool {
+			_, ok := ss.getEncoder(Encoding(protocol))
+			return ok
+		},
+	}
+	if ss.CompressionEnabled {
+		upgrader.Negotiate = negotiation.negotiate
+	}
+
+	conn, rw, handshake, err := upgrader.Upgrade(r, w)
+	if err != nil {
+		AppLogger.Errorln("[ServeHTTP] error occurred while upgrading HTTP connection to websocket: ", err)
+		return
+	}
+
+	AppLogger.Infoln("incoming connection from: ", conn.RemoteAddr())
+
+	if ss.closeIfShuttingDown(conn) {
+		return
+	}
+
+	// frames the client sent right after the handshake may already sit in the buffer of the hijacked connection
+	if rw != nil && rw.Reader.Buffered() > 0 {
+		conn = &bufferedConn{Conn: conn, reader: rw.Reader}
+	}
+
+	ss.handleMessages(conn, &upgradeInfo{
+		encoder:     ss.negotiatedEncoder(handshake.Protocol),
+		certificate: verifiedClientCertificate(r.TLS),
+		compression: negotiation.accepted,
+		token:       capture.token(),
+	})
+}
+
+// runs the upgrade callbacks the raw listener runs through ws.Upgrader against the request and returns
+// the headers to add to the handshake response
+func (ss *SocketServer) runUpgradeCallbacks(r *http.Request) (http.Header, error) {
+
+	if ss.OnHostConnectHandler != nil {
+		if err := ss.OnHostConnectHandler([]byte(r.Host)); err != nil {
+			return nil, err
+		}
+	}
+
+	if ss.OnHeaderHandler != nil {
+		for key, values := range r.Header {
+			for _, value := range values {
+				if err := ss.OnHeaderHandler([]byte(key), []byte(value)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if ss.OnBeforeUpgrade == nil {
+		return nil, nil
+	}
+
+	handshakeHeader, err := ss.OnBeforeUpgrade()
+	if err != nil || handshakeHeader == nil {
+		return nil, err
+	}
+	return toHTTPHeader(handshakeHeader)
+}
+
+// converts a handshake header to an http.Header by parsing what it writes
+func toHTTPHeader(handshakeHeader ws.HandshakeHeader) (http.Header, error) {
+
+	if header, ok := handshakeHeader.(ws.HandshakeHeaderHTTP); ok {
+		return http.Header(header), nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := handshakeHeader.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+
+	header, err := textproto.NewReader(bufio.NewReader(&buf)).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return http.Header(header), nil
+}
+
+// connection whose reads go through the buffer filled while serving the upgrade request
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.reader.Read(p)
+}
@@ -1,13 +1,25 @@
 package server
 
+import "github.com/gobwas/ws"
+
 type IEncoder interface {
 	Decode([]byte) (*Message, error)
 	Encode(Message) ([]byte)
+
+	// op code of the frames carrying the encoded messages, ws.OpText or ws.OpBinary
+	OpCode() ws.OpCode
 }
 
 
 // Application Message format
 type Message struct {
-	Event	string					`json:"event"`
-	Payload	map[string]interface{}	`json:"payload"`
+	Event	string					`json:"event" msgpack:"event"`
+
+	// set by the sender of a request which expects a reply
+	Id		string					`json:"id,omitempty" msgpack:"id,omitempty"`
+
+	// set on a reply to the Id of the request it answers
+	ReplyTo	string					`json:"reply_to,omitempty" msgpack:"reply_to,omitempty"`
+
+	Payload	map[string]interface{}	`json:"payload" msgpack:"payload"`
 }
\ No newline at end of file
@@ -1,6 +1,9 @@
 package server
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"github.com/gobwas/ws"
+)
 
 type JsonEncoder struct {
 }
@@ -27,3 +30,8 @@ func (je *JsonEncoder) Encode(data Message) []byte {
 	}
 	return bytes
 }
+
+// JSON is a text format
+func (je *JsonEncoder) OpCode() ws.OpCode {
+	return ws.OpText
+}
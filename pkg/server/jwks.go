@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+const (
+
+	// signing algorithms of the tokens the JWTAuthenticator verifies
+	JWT_ALG_HS256 = "HS256"
+	JWT_ALG_RS256 = "RS256"
+	JWT_ALG_EDDSA = "EdDSA"
+
+)
+
+// key of a JSON Web Key Set as found on disk, only the members needed to verify signatures are read
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// symmetric key
+	K string `json:"k"`
+
+	// RSA public key
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// octet key pair public key
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// key a token signature is verified with, a token is only verified by the keys of its own algorithm
+type verificationKey struct {
+	kid string
+	alg string
+
+	// []byte for HS256, *rsa.PublicKey for RS256 and ed25519.PublicKey for EdDSA
+	key interface{}
+}
+
+// serves the keys loaded from a JWKS file and loads them again once the file changes on disk, so that
+// rotated keys are picked up without restarting the server
+type keySet struct {
+	files *fileReloader
+}
+
+func newKeySet(file string, checkInterval time.Duration) (*keySet, error) {
+
+	load := func() (interface{}, error) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return parseKeySet(data)
+	}
+
+	files, err := newFileReloader("key set", checkInterval, load, file)
+	if err != nil {
+		return nil, err
+	}
+	return &keySet{files: files}, nil
+}
+
+// the keys a token signed with the algorithm and carrying the key id may be verified with, every key of the
+// algorithm when the token carries no key id. A key set which fails to load keeps the previous keys served
+func (ks *keySet) lookup(kid, alg string) []*verificationKey {
+
+	var keys []*verificationKey
+	for _, key := range ks.files.current().([]*verificationKey) {
+		if key.alg == alg && (kid == "" || key.kid == kid) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parses the signing keys of a JWKS document, skipping the ones of an unsupported type
+func parseKeySet(data []byte) ([]*verificationKey, error) {
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*verificationKey, 0, len(document.Keys))
+	for _, jwk := range document.Keys {
+
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// the set may hold keys meant for other algorithms than the supported ones
+		key, err := jwk.verificationKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("key set holds no usable signing key")
+	}
+	return keys, nil
+}
+
+func (jwk *jsonWebKey) verificationKey() (*verificationKey, error) {
+
+	vk := &verificationKey{kid: jwk.Kid, alg: jwk.Alg}
+
+	switch jwk.Kty {
+
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid symmetric key")
+		}
+		vk.key = secret
+		if vk.alg == "" {
+			vk.alg = JWT_ALG_HS256
+		}
+
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil || len(n) == 0 {
+			return nil, errors.New("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		vk.key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if vk.alg == "" {
+			vk.alg = JWT_ALG_RS256
+		}
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		vk.key = ed25519.PublicKey(x)
+		if vk.alg == "" {
+			vk.alg = JWT_ALG_EDDSA
+		}
+
+	default:
+		return nil, errors.New("unsupported key type: " + jwk.Kty)
+	}
+
+	if !vk.matchesAlgorithm() {
+		return nil, errors.New("algorithm does not match the key type: " + vk.alg)
+	}
+	return vk, nil
+}
+
+// whether the algorithm declared for the key is the one its type is used with
+func (vk *verificationKey) matchesAlgorithm() bool {
+	switch vk.key.(type) {
+	case []byte:
+		return vk.alg == JWT_ALG_HS256
+	case *rsa.PublicKey:
+		return vk.alg == JWT_ALG_RS256
+	case ed25519.PublicKey:
+		return vk.alg == JWT_ALG_EDDSA
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+
+	// client metadata keys holding the identity of a client authenticated by a JWT
+	MetadataJWTSubject = "jwt_subject"
+	MetadataJWTGroups = "jwt_groups"
+	MetadataJWTExpiry = "jwt_expiry"
+	MetadataJWTClaims = "jwt_claims"
+
+	// field of the authentication message payload carrying the token
+	AuthTokenField = "token"
+
+)
+
+var (
+	errTokenMissing              = errors.New("token missing")
+	errTokenMalformed            = errors.New("token malformed")
+	errTokenUnsupportedAlgorithm = errors.New("token signing algorithm not supported")
+	errTokenSignature            = errors.New("token signature invalid")
+	errTokenExpired              = errors.New("token expired")
+	errTokenNotYetValid          = errors.New("token not valid yet")
+	errTokenIssuer               = errors.New("token issuer not accepted")
+	errTokenAudience             = errors.New("token audience not accepted")
+)
+
+// claims of a verified token
+type JWTClaims struct {
+	Subject   string
+	Groups    []string
+	ExpiresAt time.Time
+
+	// every claim of the token as decoded from its JSON payload
+	Claims map[string]interface{}
+}
+
+// client metadata describing the claims
+func (jc *JWTClaims) metadata() map[string]interface{} {
+	return map[string]interface{}{
+		MetadataJWTSubject: jc.Subject,
+		MetadataJWTGroups:  jc.Groups,
+		MetadataJWTExpiry:  jc.ExpiresAt,
+		MetadataJWTClaims:  jc.Claims,
+	}
+}
+
+// authenticates clients by a JWT signed with one of the keys of a JWKS file, which is reloaded when rotated
+// on disk. Its AuthHandler is meant to be set as the AuthHandler of the server:
+//
+//	authenticator, err := server.NewJWTAuthenticator("/etc/brisk/jwks.json", time.Minute)
+//	socketServer.AuthHandler = authenticator.SetIssuer("https://auth.example.com").AuthHandler
+type JWTAuthenticator struct {
+	keys *keySet
+
+	// accepted issuer and audience, not checked when empty
+	issuer   string
+	audience string
+
+	// tolerated clock skew when checking the validity period of a token
+	leeway time.Duration
+
+	// claim holding the groups of the subject
+	groupsClaim string
+}
+
+func NewJWTAuthenticator(jwksFile string, reloadInterval time.Duration) (*JWTAuthenticator, error) {
+
+	keys, err := newKeySet(jwksFile, reloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTAuthenticator{keys: keys, leeway: 30 * time.Second, groupsClaim: "groups"}, nil
+}
+
+func (ja *JWTAuthenticator) SetIssuer(issuer string) *JWTAuthenticator {
+	ja.issuer = issuer
+	return ja
+}
+
+func (ja *JWTAuthenticator) SetAudience(audience string) *JWTAuthenticator {
+	ja.audience = audience
+	return ja
+}
+
+func (ja *JWTAuthenticator) SetLeeway(leeway time.Duration) *JWTAuthenticator {
+	ja.leeway = leeway
+	return ja
+}
+
+func (ja *JWTAuthenticator) SetGroupsClaim(claim string) *JWTAuthenticator {
+	ja.groupsClaim = claim
+	return ja
+}
+
+// AuthHandler implementation verifying the token of the authentication message, or else the one presented
+// during the upgrade. The claims of an accepted token are attached to the client metadata, and the client is
+// disconnected at its expiry unless it authenticates again with a fresh token
+func (ja *JWTAuthenticator) AuthHandler(req *AuthRequest) (bool, string) {
+
+	token := req.Token
+	if req.Message != nil && req.Message.Event == AuthenticationEvent {
+		if payloadToken, _ := req.Message.Payload[AuthTokenField].(string); payloadToken != "" {
+			token = payloadToken
+		}
+	}
+
+	claims, err := ja.Authenticate(token)
+	if err != nil {
+		AppLogger.Infof("[AuthHandler] client: %s token rejected: %v", req.ClientId, err)
+		return false, err.Error()
+	}
+
+	req.UserId = claims.Subject
+	req.Metadata = claims.metadata()
+	req.ExpiresAt = claims.ExpiresAt
+	return true, "authenticated as " + claims.Subject
+}
+
+// verifies the signature and the registered claims of the token and returns its claims
+func (ja *JWTAuthenticator) Authenticate(token string) (*JWTClaims, error) {
+
+	if token == "" {
+		return nil, errTokenMissing
+	}
+
+	claims, err := verifyJWT(token, ja.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// a token without expiry would stay valid forever
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, errTokenMalformed
+	}
+	if now.After(exp.Add(ja.leeway)) {
+		return nil, errTokenExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(ja.leeway).Before(nbf) {
+		return nil, errTokenNotYetValid
+	}
+
+	if ja.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != ja.issuer {
+			return nil, errTokenIssuer
+		}
+	}
+	if ja.audience != "" && !containsString(stringList(claims["aud"]), ja.audience) {
+		return nil, errTokenAudience
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &JWTClaims{
+		Subject:   subject,
+		Groups:    stringList(claims[ja.groupsClaim]),
+		ExpiresAt: exp,
+		Claims:    claims,
+	}, nil
+}
+
+// checks the signature of a compact serialized JWS against the keys of its algorithm and returns its claims
+func verifyJWT(token string, keys *keySet) (map[string]interface{}, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errTokenMalformed
+	}
+
+	switch header.Alg {
+	case JWT_ALG_HS256, JWT_ALG_RS256, JWT_ALG_EDDSA:
+	default:
+		return nil, errTokenUnsupportedAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+	verified := false
+	for _, key := range keys.lookup(header.Kid, header.Alg) {
+		if key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errTokenSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+
+	// numbers are kept as json.Number so that large integer claims are not rounded
+	var claims map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil || claims == nil {
+		return nil, errTokenMalformed
+	}
+	return claims, nil
+}
+
+func (vk *verificationKey) verify(signed, signature []byte) bool {
+
+	switch key := vk.key.(type) {
+
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, signature)
+	}
+	return false
+}
+
+// bound of the NumericDate claims, far beyond any meaningful date while keeping the time arithmetic in range
+const maxNumericDate = 1 << 53
+
+// time of a NumericDate claim, the number of seconds since the epoch
+func numericDate(claim interface{}) (time.Time, bool) {
+
+	number, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return time.Time{}, false
+	}
+
+	// whole seconds and nanoseconds are kept apart, as nanoseconds since the epoch overflow past the year 2262
+	seconds = math.Max(-maxNumericDate, math.Min(seconds, maxNumericDate))
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second))), true
+}
+
+// values of a claim holding either a single string or an array of them
+func stringList(claim interface{}) []string {
+
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
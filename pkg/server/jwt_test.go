@@ -0,0 +1,318 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signs tokens with one key and describes it as a JWK
+type testSigner struct {
+	kid  string
+	alg  string
+	jwk  map[string]interface{}
+	sign func(signed []byte) []byte
+}
+
+func newHS256Signer(t *testing.T, kid string) *testSigner {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{
+		kid: kid,
+		alg: JWT_ALG_HS256,
+		jwk: map[string]interface{}{"kty": "oct", "kid": kid, "k": base64.RawURLEncoding.EncodeToString(secret)},
+		sign: func(signed []byte) []byte {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(signed)
+			return mac.Sum(nil)
+		},
+	}
+}
+
+func newRS256Signer(t *testing.T, kid string) *testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{
+		kid: kid,
+		alg: JWT_ALG_RS256,
+		jwk: map[string]interface{}{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		},
+	}
+}
+
+func newEdDSASigner(t *testing.T, kid string) *testSigner {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{
+		kid: kid,
+		alg: JWT_ALG_EDDSA,
+		jwk: map[string]interface{}{"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": base64.RawURLEncoding.EncodeToString(public)},
+		sign: func(signed []byte) []byte {
+			return ed25519.Sign(private, signed)
+		},
+	}
+}
+
+func (ts *testSigner) token(t *testing.T, header, claims map[string]interface{}) string {
+	encode := func(value map[string]interface{}) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ts.sign([]byte(signed)))
+}
+
+// token signed by the key and naming it in its header
+func (ts *testSigner) signedToken(t *testing.T, claims map[string]interface{}) string {
+	return ts.token(t, map[string]interface{}{"alg": ts.alg, "kid": ts.kid, "typ": "JWT"}, claims)
+}
+
+// writes the keys of the signers as a JWKS file
+func writeKeySet(t *testing.T, file string, signers ...*testSigner) {
+	keys := make([]map[string]interface{}, 0, len(signers))
+	for _, signer := range signers {
+		keys = append(keys, signer.jwk)
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rewrites the file with a modification time distinct from the previous one, however coarse the file system clock
+func rewriteFile(t *testing.T, file string, write func()) {
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write()
+	modTime := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+
+	hs256 := newHS256Signer(t, "hs")
+	rs256 := newRS256Signer(t, "rs")
+	eddsa := newEdDSASigner(t, "ed")
+	unknown := newEdDSASigner(t, "ed")
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeKeySet(t, file, hs256, rs256, eddsa)
+
+	authenticator, err := NewJWTAuthenticator(file, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.SetIssuer("https://issuer").SetAudience("brisk").SetLeeway(time.Minute)
+
+	now := time.Now().Unix()
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"sub":    "alice",
+			"iss":    "https://issuer",
+			"aud":    "brisk",
+			"exp":    now + 3600,
+			"groups": []string{"admins", "users"},
+		}
+		for name, value := range extra {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		err   error
+	}{
+		{"HS256", func() string { return hs256.signedToken(t, valid(nil)) }, nil},
+		{"RS256", func() string { return rs256.signedToken(t, valid(nil)) }, nil},
+		{"EdDSA", func() string { return eddsa.signedToken(t, valid(nil)) }, nil},
+		{
+			name: "without key id",
+			token: func() string {
+				return rs256.token(t, map[string]interface{}{"alg": JWT_ALG_RS256}, valid(nil))
+			},
+		},
+		{"unknown key", func() string { return unknown.signedToken(t, valid(nil)) }, errTokenSignature},
+		{
+			name: "algorithm not matching the key",
+			token: func() string {
+				return hs256.token(t, map[string]interface{}{"alg": JWT_ALG_RS256, "kid": "hs"}, valid(nil))
+			},
+			err: errTokenSignature,
+		},
+		{
+			name: "unsupported algorithm",
+			token: func() string {
+				return hs256.token(t, map[string]interface{}{"alg": "none", "kid": "hs"}, valid(nil))
+			},
+			err: errTokenUnsupportedAlgorithm,
+		},
+		{
+			name: "tampered claims",
+			token: func() string {
+				parts := strings.Split(eddsa.signedToken(t, valid(nil)), ".")
+				forged, _ := json.Marshal(valid(map[string]interface{}{"sub": "mallory"}))
+				parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+				return strings.Join(parts, ".")
+			},
+			err: errTokenSignature,
+		},
+		{"missing", func() string { return "" }, errTokenMissing},
+		{"malformed", func() string { return "a.b" }, errTokenMalformed},
+		{"without expiry", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"exp": nil})) }, errTokenMalformed},
+		{"expired", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"exp": now - 120})) }, errTokenExpired},
+		{"expired within the leeway", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"exp": now - 30})) }, nil},
+		{"expiring in the year 3000", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"exp": 32503680000})) }, nil},
+		{"expiring beyond any date", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"exp": 1e300})) }, nil},
+		{"not valid yet", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"nbf": now + 120})) }, errTokenNotYetValid},
+		{"not valid yet within the leeway", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"nbf": now + 30})) }, nil},
+		{"other issuer", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"iss": "https://other"})) }, errTokenIssuer},
+		{"without issuer", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"iss": nil})) }, errTokenIssuer},
+		{"audience list", func() string {
+			return hs256.signedToken(t, valid(map[string]interface{}{"aud": []string{"other", "brisk"}}))
+		}, nil},
+		{"other audience", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"aud": []string{"other"}})) }, errTokenAudience},
+		{"without audience", func() string { return hs256.signedToken(t, valid(map[string]interface{}{"aud": nil})) }, errTokenAudience},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			claims, err := authenticator.Authenticate(tt.token())
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if claims.Subject != "alice" || strings.Join(claims.Groups, ",") != "admins,users" {
+				t.Fatalf("got subject %q and groups %v", claims.Subject, claims.Groups)
+			}
+			if !claims.ExpiresAt.After(time.Now().Add(-time.Minute)) {
+				t.Fatalf("got expiry %v", claims.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestNumericDate(t *testing.T) {
+
+	tests := []struct {
+		claim interface{}
+		want  time.Time
+		ok    bool
+	}{
+		{json.Number("0"), time.Unix(0, 0), true},
+		{json.Number("1700000000"), time.Unix(1700000000, 0), true},
+		{json.Number("1700000000.5"), time.Unix(1700000000, 500000000), true},
+		{json.Number("32503680000"), time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{json.Number("-1"), time.Unix(-1, 0), true},
+		{json.Number("1e300"), time.Unix(maxNumericDate, 0), true},
+		{json.Number("-1e300"), time.Unix(-maxNumericDate, 0), true},
+		{json.Number("soon"), time.Time{}, false},
+		{"1700000000", time.Time{}, false},
+		{nil, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := numericDate(tt.claim)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("%v: got %v, %v, want %v, %v", tt.claim, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+
+	previous := newEdDSASigner(t, "2023")
+	next := newEdDSASigner(t, "2024")
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeKeySet(t, file, previous)
+
+	// the file is checked on every authentication
+	authenticator, err := NewJWTAuthenticator(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	authenticate := func(signer *testSigner) error {
+		_, err := authenticator.Authenticate(signer.signedToken(t, claims))
+		return err
+	}
+
+	if err := authenticate(previous); err != nil {
+		t.Fatalf("previous key: %v", err)
+	}
+	if err := authenticate(next); err != errTokenSignature {
+		t.Fatalf("next key before the rotation: got %v, want %v", err, errTokenSignature)
+	}
+
+	rewriteFile(t, file, func() { writeKeySet(t, file, next) })
+	if err := authenticate(next); err != nil {
+		t.Fatalf("next key after the rotation: %v", err)
+	}
+	if err := authenticate(previous); err != errTokenSignature {
+		t.Fatalf("previous key after the rotation: got %v, want %v", err, errTokenSignature)
+	}
+
+	// a key set which fails to load keeps the current keys
+	rewriteFile(t, file, func() {
+		if err := ioutil.WriteFile(file, []byte("{not json"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := authenticate(next); err != nil {
+		t.Fatalf("next key after a broken rotation: %v", err)
+	}
+
+	if _, err := NewJWTAuthenticator(file, 0); err == nil {
+		t.Fatal("authenticator created from a broken key set")
+	}
+}
@@ -0,0 +1,12 @@
+package server
+
+import (
+	"os"
+	"testing"
+)
+
+// the logger is otherwise only set up by NewSocketServer
+func TestMain(m *testing.M) {
+	InitLogger(ErrorLevel, false)
+	os.Exit(m.Run())
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// in-process Broker, useful when running a single node or in tests. Several nodes of the same process
+// exchange their broadcasts through brokers made by Connect, each node holding its own broker the way
+// each node holds its own connection to redis:
+//
+//	first := server.NewSocketServer(cfg, broker)
+//	second := server.NewSocketServer(cfg, broker.Connect())
+type MemoryBroker struct {
+	sync.Mutex
+
+	hub				*memoryHub
+
+	// buffer size of every subscription channel
+	bufferSize		int64
+
+	// subscriptions made through this broker, by channel
+	subscriptions	map[string][]chan []byte
+}
+
+// the subscriptions of every broker connected together, by channel
+type memoryHub struct {
+	sync.RWMutex
+
+	subscriptions	map[string]map[chan []byte]struct{}
+}
+
+func NewMemoryBroker(bufferSize int64) *MemoryBroker {
+	return newMemoryBroker(&memoryHub{subscriptions: make(map[string]map[chan []byte]struct{})}, bufferSize)
+}
+
+func newMemoryBroker(hub *memoryHub, bufferSize int64) *MemoryBroker {
+	return &MemoryBroker{
+		hub:           hub,
+		bufferSize:    bufferSize,
+		subscriptions: make(map[string][]chan []byte),
+	}
+}
+
+// returns a new broker exchanging data with this one, to be used by another node
+func (mb *MemoryBroker) Connect() *MemoryBroker {
+	return newMemoryBroker(mb.hub, mb.bufferSize)
+}
+
+// delivers a copy of the data to every subscription of the channel, across the connected brokers
+func (mb *MemoryBroker) Publish(ctx context.Context, channel string, data []byte) error {
+
+	mb.hub.RLock()
+	defer mb.hub.RUnlock()
+
+	for sub := range mb.hub.subscriptions[channel] {
+		select {
+		case sub <- data:
+		default:
+			AppLogger.Errorf("[MemoryBroker.Publish] channel: %s subscription buffer is full, dropping data", channel)
+		}
+	}
+	return nil
+}
+
+func (mb *MemoryBroker) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+
+	mb.Lock()
+	defer mb.Unlock()
+
+	sub := make(chan []byte, mb.bufferSize)
+	mb.subscriptions[channel] = append(mb.subscriptions[channel], sub)
+
+	mb.hub.Lock()
+	subs, ok := mb.hub.subscriptions[channel]
+	if !ok {
+		subs = make(map[chan []byte]struct{})
+		mb.hub.subscriptions[channel] = subs
+	}
+	subs[sub] = struct{}{}
+	mb.hub.Unlock()
+
+	return sub, nil
+}
+
+// cancels the subscriptions made through this broker to the channel, the other brokers keep theirs
+func (mb *MemoryBroker) Unsubscribe(ctx context.Context, channel string) error {
+
+	mb.Lock()
+	defer mb.Unlock()
+
+	mb.unsubscribe(channel)
+	return nil
+}
+
+// cancels every subscription made through this broker
+func (mb *MemoryBroker) Close() error {
+
+	mb.Lock()
+	defer mb.Unlock()
+
+	for channel := range mb.subscriptions {
+		mb.unsubscribe(channel)
+	}
+	return nil
+}
+
+// must hold the broker lock
+func (mb *MemoryBroker) unsubscribe(channel string) {
+
+	subs, ok := mb.subscriptions[channel]
+	if !ok {
+		return
+	}
+	delete(mb.subscriptions, channel)
+
+	// closed under the hub lock so that no publisher sends on them anymore
+	mb.hub.Lock()
+	for _, sub := range subs {
+		delete(mb.hub.subscriptions[channel], sub)
+		close(sub)
+	}
+	if len(mb.hub.subscriptions[channel]) == 0 {
+		delete(mb.hub.subscriptions, channel)
+	}
+	mb.hub.Unlock()
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerDeliversToEverySubscriber(t *testing.T) {
+
+	first := NewMemoryBroker(10)
+	second := first.Connect()
+	ctx := context.Background()
+
+	subs := make([]<-chan []byte, 0, 3)
+	for _, broker := range []*MemoryBroker{first, first, second} {
+		sub, err := broker.Subscribe(ctx, "group")
+		if err != nil {
+			t.Fatalf("subscribe: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := second.Publish(ctx, "group", []byte{byte(i)}); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+
+	for n, sub := range subs {
+		for i := 0; i < 5; i++ {
+			select {
+			case data := <-sub:
+				if data[0] != byte(i) {
+					t.Fatalf("subscriber %d: got %d, want %d", n, data[0], i)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("subscriber %d: missing data %d", n, i)
+			}
+		}
+	}
+}
+
+func TestMemoryBrokerUnsubscribeKeepsOtherBrokers(t *testing.T) {
+
+	first := NewMemoryBroker(10)
+	second := first.Connect()
+	ctx := context.Background()
+
+	firstSub, _ := first.Subscribe(ctx, "group")
+	secondSub, _ := second.Subscribe(ctx, "group")
+
+	if err := first.Unsubscribe(ctx, "group"); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	if _, open := <-firstSub; open {
+		t.Fatal("subscription of the unsubscribed broker is still open")
+	}
+
+	if err := first.Publish(ctx, "group", []byte("data")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case data, open := <-secondSub:
+		if !open || string(data) != "data" {
+			t.Fatalf("got %q open: %v, want data", data, open)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription of the other broker got nothing")
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, open := <-secondSub; open {
+		t.Fatal("subscription is still open after close")
+	}
+}
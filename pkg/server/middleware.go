@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// wraps an EventHandler with some logic to run around it, much like a net/http middleware.
+// Returning an error without calling next short-circuits the chain, the error is sent back to the client
+type Middleware func(next EventHandler) EventHandler
+
+// chains the middlewares around the handler, the first middleware being the outermost one
+func chainMiddlewares(handler EventHandler, middlewares []Middleware) EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// logs every handled event along with the time it took and the error it failed with
+func LoggingMiddleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx *EventContext) error {
+
+			start := time.Now()
+			err := next(ctx)
+
+			if err != nil {
+				AppLogger.Errorf("[LoggingMiddleware] client: %s event: %s failed after %v: %v",
+					ctx.Client.Id, ctx.Event(), time.Since(start), err)
+			} else {
+				AppLogger.Infof("[LoggingMiddleware] client: %s event: %s handled in %v",
+					ctx.Client.Id, ctx.Event(), time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// turns a panicking handler into an internal error sent back to the client instead of crashing the server
+func RecoveryMiddleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx *EventContext) (err error) {
+
+			defer func() {
+				if r := recover(); r != nil {
+					AppLogger.Errorf("[RecoveryMiddleware] client: %s event: %s handler panicked: %v", ctx.Client.Id, ctx.Event(), r)
+					err = NewHandlerError(ErrCodeInternal, "internal error")
+				}
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+// rejects the events of clients whose metadata does not hold every one of the given keys,
+// typically the keys set on successful authentication
+func RequireMetadataMiddleware(keys ...string) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx *EventContext) error {
+
+			for _, key := range keys {
+				if ctx.Client.GetMetadata(key) == nil {
+					return NewHandlerError(ErrCodeUnauthorized, "client is not allowed to send this event")
+				}
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// rejects the events whose payload the validator finds invalid
+func ValidatePayloadMiddleware(validator func(event string, payload map[string]interface{}) error) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx *EventContext) error {
+
+			if err := validator(ctx.Event(), ctx.Payload()); err != nil {
+				return NewHandlerError(ErrCodeInvalidPayload, err.Error())
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// rejects the events whose payload does not hold every one of the given fields
+func RequirePayloadFieldsMiddleware(fields ...string) Middleware {
+	return ValidatePayloadMiddleware(func(event string, payload map[string]interface{}) error {
+		for _, field := range fields {
+			if _, ok := payload[field]; !ok {
+				return fmt.Errorf("missing payload field: %s", field)
+			}
+		}
+		return nil
+	})
+}
+
+// token bucket of a single client
+type rateBucket struct {
+	tokens		float64
+	lastSeen	time.Time
+}
+
+// limits every client to eventsPerSecond events on average with bursts of up to burst events,
+// the events exceeding the limit are rejected. Panics unless the rate is a positive finite number
+// and the burst at least 1, as no event could be let through otherwise
+func RateLimitMiddleware(eventsPerSecond float64, burst int) Middleware {
+
+	if !(eventsPerSecond > 0) || math.IsInf(eventsPerSecond, 1) {
+		panic(fmt.Sprintf("RateLimitMiddleware: invalid rate: %v events per second", eventsPerSecond))
+	}
+	if burst < 1 {
+		panic(fmt.Sprintf("RateLimitMiddleware: invalid burst: %d events", burst))
+	}
+
+	var lock sync.Mutex
+	buckets := make(map[string]*rateBucket)
+	lastCleanup := time.Now()
+
+	// a bucket idle for that long is full again and can be forgotten
+	idleAfter := time.Duration(float64(burst) / eventsPerSecond * float64(time.Second))
+
+	allow := func(clientId string) bool {
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		now := time.Now()
+
+		// forgetting the buckets of the clients which went away
+		if now.Sub(lastCleanup) > idleAfter {
+			for id, bucket := range buckets {
+				if now.Sub(bucket.lastSeen) > idleAfter {
+					delete(buckets, id)
+				}
+			}
+			lastCleanup = now
+		}
+
+		bucket, ok := buckets[clientId]
+		if !ok {
+			bucket = &rateBucket{tokens: float64(burst), lastSeen: now}
+			buckets[clientId] = bucket
+		}
+
+		bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*eventsPerSecond)
+		bucket.lastSeen = now
+
+		if bucket.tokens < 1 {
+			return false
+		}
+		bucket.tokens--
+		return true
+	}
+
+	return func(next EventHandler) EventHandler {
+		return func(ctx *EventContext) error {
+
+			if !allow(ctx.Client.Id) {
+				return NewHandlerError(ErrCodeRateLimited, "too many events, slow down")
+			}
+			return next(ctx)
+		}
+	}
+}
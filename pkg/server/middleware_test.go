@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRateLimitMiddlewareRejectsInvalidLimits(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		eventsPerSecond float64
+		burst           int
+	}{
+		{"zero rate", 0, 5},
+		{"negative rate", -1, 5},
+		{"NaN rate", math.NaN(), 5},
+		{"infinite rate", math.Inf(1), 5},
+		{"zero burst", 10, 0},
+		{"negative burst", 10, -3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected a panic")
+				}
+			}()
+			RateLimitMiddleware(tt.eventsPerSecond, tt.burst)
+		})
+	}
+}
+
+func TestRateLimitMiddlewareLimitsEachClient(t *testing.T) {
+
+	handler := RateLimitMiddleware(0.001, 3)(func(ctx *EventContext) error {
+		return nil
+	})
+
+	first := &EventContext{Client: &socketClient{Id: "first"}, Message: &Message{Event: "chat"}}
+	second := &EventContext{Client: &socketClient{Id: "second"}, Message: &Message{Event: "chat"}}
+
+	for i := 0; i < 3; i++ {
+		if err := handler(first); err != nil {
+			t.Fatalf("event %d within the burst rejected: %v", i, err)
+		}
+	}
+
+	var handlerErr *HandlerError
+	if err := handler(first); !errors.As(err, &handlerErr) || handlerErr.Code != ErrCodeRateLimited {
+		t.Fatalf("got %v, want a %s error", err, ErrCodeRateLimited)
+	}
+
+	if err := handler(second); err != nil {
+		t.Fatalf("other client rejected: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"bytes"
+	"github.com/gobwas/ws"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type MsgPackEncoder struct {
+}
+
+// decodes the byte array into Application specific Message format
+func (me *MsgPackEncoder) Decode(data []byte) (*Message, error) {
+
+	var res Message
+
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	// numbers of the payload are decoded as int64, uint64 and float64 whatever their size on the wire
+	decoder.UseLooseInterfaceDecoding(true)
+
+	if err := decoder.Decode(&res); err != nil {
+		AppLogger.Errorln("[Decode] error occurred while unpacking bytes to Message: ", err)
+		return nil, err
+	}
+	return &res, nil
+}
+
+// encodes the application specific Message format into byte array
+func (me *MsgPackEncoder) Encode(data Message) []byte {
+
+	bytes, err := msgpack.Marshal(data)
+	if err != nil {
+		AppLogger.Errorln("[Encode] error occurred while packing Message to bytes: ", err)
+		return nil
+	}
+	return bytes
+}
+
+// MessagePack is a binary format
+func (me *MsgPackEncoder) OpCode() ws.OpCode {
+	return ws.OpBinary
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+func TestMsgPackEncoderRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "scalar values",
+			payload: map[string]interface{}{"text": "hi", "count": 3, "big": uint64(1) << 63, "ratio": 1.5, "ok": true, "none": nil},
+			want:    map[string]interface{}{"text": "hi", "count": int64(3), "big": uint64(1) << 63, "ratio": 1.5, "ok": true, "none": nil},
+		},
+		{
+			// positive integers past the fixint range are packed unsigned and come back as uint64
+			name: "nested maps",
+			payload: map[string]interface{}{
+				"user": map[string]interface{}{"name": "alice", "address": map[string]interface{}{"city": "Paris", "zip": 75001}},
+			},
+			want: map[string]interface{}{
+				"user": map[string]interface{}{"name": "alice", "address": map[string]interface{}{"city": "Paris", "zip": uint64(75001)}},
+			},
+		},
+		{
+			name:    "lists",
+			payload: map[string]interface{}{"tags": []string{"a", "b"}, "items": []interface{}{map[string]interface{}{"id": 1}, "x"}},
+			want:    map[string]interface{}{"tags": []interface{}{"a", "b"}, "items": []interface{}{map[string]interface{}{"id": int64(1)}, "x"}},
+		},
+		{
+			name:    "raw bytes",
+			payload: map[string]interface{}{RawPayloadKey: []byte{0, 1, 255}},
+			want:    map[string]interface{}{RawPayloadKey: []byte{0, 1, 255}},
+		},
+	}
+
+	encoder := &MsgPackEncoder{}
+	if encoder.OpCode() != ws.OpBinary {
+		t.Fatalf("got op code %v, want binary frames", encoder.OpCode())
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			data := encoder.Encode(Message{Event: "event", Id: "id", ReplyTo: "request", Payload: tt.payload})
+			if data == nil {
+				t.Fatal("message not encoded")
+			}
+
+			decoded, err := encoder.Decode(data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if decoded.Event != "event" || decoded.Id != "id" || decoded.ReplyTo != "request" {
+				t.Fatalf("got event: %q id: %q reply to: %q", decoded.Event, decoded.Id, decoded.ReplyTo)
+			}
+			if !reflect.DeepEqual(decoded.Payload, tt.want) {
+				t.Fatalf("got payload %#v, want %#v", decoded.Payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgPackEncoderRejectsGarbage(t *testing.T) {
+	if _, err := (&MsgPackEncoder{}).Decode([]byte{0xc1}); err == nil {
+		t.Fatal("garbage decoded")
+	}
+}
@@ -0,0 +1,228 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// time a worker waits for the rest of a frame once the socket started delivering it
+const netpollReadTimeout = 10 * time.Second
+
+// waits with epoll for the watched connections to be readable and reads them on the worker pool, so that
+// an idle connection holds no goroutine
+type netpoll struct {
+	epollFd int
+
+	// pipe written to on close to wake the waiting goroutine up
+	wakeRead  int
+	wakeWrite int
+
+	pool *workerPool
+
+	lock    sync.Mutex
+	watched map[int]*polledConn
+	closed  bool
+}
+
+// a connection watched by the netpoll, it is armed for a single readiness notification at a time so that
+// only one worker reads it at once
+type polledConn struct {
+	netpoll    *netpoll
+	fd         int
+	conn       net.Conn
+	onReadable func() bool
+}
+
+func newNetpoll(pool *workerPool) (*netpoll, error) {
+
+	epollFd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	var wake [2]int
+	if err := syscall.Pipe2(wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epollFd)
+		return nil, err
+	}
+
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(wake[0])}
+	if err := syscall.EpollCtl(epollFd, syscall.EPOLL_CTL_ADD, wake[0], event); err != nil {
+		syscall.Close(epollFd)
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		return nil, err
+	}
+
+	np := &netpoll{
+		epollFd:   epollFd,
+		wakeRead:  wake[0],
+		wakeWrite: wake[1],
+		pool:      pool,
+		watched:   make(map[int]*polledConn),
+	}
+	go np.wait()
+	return np, nil
+}
+
+// registers the connection without watching it yet, returns nil when the connection can't be polled:
+// the bytes buffered by a TLS connection or by the HTTP server are invisible to epoll
+func (np *netpoll) register(conn net.Conn, onReadable func() bool) *polledConn {
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	fd := -1
+	if err := rawConn.Control(func(sysFd uintptr) { fd = int(sysFd) }); err != nil || fd < 0 {
+		return nil
+	}
+
+	return &polledConn{netpoll: np, fd: fd, conn: conn, onReadable: onReadable}
+}
+
+// starts watching the connection
+func (pc *polledConn) arm() error {
+
+	np := pc.netpoll
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	if np.closed {
+		return syscall.EBADF
+	}
+
+	if err := syscall.EpollCtl(np.epollFd, syscall.EPOLL_CTL_ADD, pc.fd, pc.event()); err != nil {
+		return err
+	}
+	np.watched[pc.fd] = pc
+	return nil
+}
+
+// watches the connection for its next readiness notification
+func (pc *polledConn) rearm() {
+
+	np := pc.netpoll
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	// the connection stopped being watched while it was read
+	if np.closed || np.watched[pc.fd] != pc {
+		return
+	}
+
+	if err := syscall.EpollCtl(np.epollFd, syscall.EPOLL_CTL_MOD, pc.fd, pc.event()); err != nil {
+		AppLogger.Errorf("[rearm] error occurred while watching connection %v: %v", pc.conn.RemoteAddr(), err)
+	}
+}
+
+// stops watching the connection, must be called before it is closed so that its descriptor is not
+// reused by another connection meanwhile
+func (pc *polledConn) unwatch() {
+
+	np := pc.netpoll
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	if np.watched[pc.fd] != pc {
+		return
+	}
+	delete(np.watched, pc.fd)
+
+	if np.closed {
+		return
+	}
+	if err := syscall.EpollCtl(np.epollFd, syscall.EPOLL_CTL_DEL, pc.fd, nil); err != nil {
+		AppLogger.Debugf("[unwatch] error occurred while unwatching connection %v: %v", pc.conn.RemoteAddr(), err)
+	}
+}
+
+func (pc *polledConn) event() *syscall.EpollEvent {
+	return &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(pc.fd),
+	}
+}
+
+func (np *netpoll) wait() {
+
+	events := make([]syscall.EpollEvent, 128)
+
+	for {
+		n, err := syscall.EpollWait(np.epollFd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			AppLogger.Errorln("[wait] error occurred while waiting for readable connections: ", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+
+			fd := int(events[i].Fd)
+			if fd == np.wakeRead {
+				np.release()
+				return
+			}
+
+			np.lock.Lock()
+			pc := np.watched[fd]
+			np.lock.Unlock()
+
+			if pc != nil {
+				np.pool.submit(pc.read)
+			}
+		}
+	}
+}
+
+// reads what made the connection readable and watches it again, unless the connection is over
+func (pc *polledConn) read() {
+
+	pc.conn.SetReadDeadline(time.Now().Add(netpollReadTimeout))
+
+	if !pc.onReadable() {
+		pc.unwatch()
+		return
+	}
+
+	pc.conn.SetReadDeadline(time.Time{})
+	pc.rearm()
+}
+
+// stops waiting for readable connections, the connections themselves are left open
+func (np *netpoll) close() error {
+
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	if np.closed {
+		return nil
+	}
+	np.closed = true
+
+	_, err := syscall.Write(np.wakeWrite, []byte{0})
+	return err
+}
+
+// releases the descriptors of the netpoll once the waiting goroutine is done with them
+func (np *netpoll) release() {
+
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	syscall.Close(np.wakeRead)
+	syscall.Close(np.wakeWrite)
+	syscall.Close(np.epollFd)
+}
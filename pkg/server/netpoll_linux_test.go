@@ -0,0 +1,164 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// server reading its connections through the netpoll, replying to the echo event
+func newNetpollTestServer(t *testing.T) (*SocketServer, string) {
+
+	cfg := DefaultServerConfiguration(":0").SetNetpoll(true)
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+	if ss.netpoll == nil {
+		t.Fatal("netpoll not enabled")
+	}
+	ss.On("echo", func(ctx *EventContext) error {
+		return ctx.Reply(ctx.Payload())
+	})
+
+	srv := httptest.NewServer(ss)
+	t.Cleanup(func() {
+		srv.Close()
+		ss.Shutdown(context.Background())
+	})
+	return ss, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func echoMessage(id int, text string) []byte {
+	return []byte(fmt.Sprintf(`{"event":"echo","id":"%d","payload":{"text":%q}}`, id, text))
+}
+
+func TestNetpollMessages(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		frames func() []ws.Frame
+		texts  []string
+	}{
+		{
+			name:   "single message",
+			frames: func() []ws.Frame { return []ws.Frame{ws.NewTextFrame(echoMessage(0, "hello"))} },
+			texts:  []string{"hello"},
+		},
+		{
+			name: "messages written at once",
+			frames: func() []ws.Frame {
+				return []ws.Frame{ws.NewTextFrame(echoMessage(0, "a")), ws.NewTextFrame(echoMessage(1, "b")), ws.NewTextFrame(echoMessage(2, "c"))}
+			},
+			texts: []string{"a", "b", "c"},
+		},
+		{
+			name: "fragmented message around a ping",
+			frames: func() []ws.Frame {
+				message := echoMessage(0, "fragmented")
+				return []ws.Frame{
+					ws.NewFrame(ws.OpText, false, message[:10]),
+					ws.NewPingFrame(nil),
+					ws.NewFrame(ws.OpContinuation, true, message[10:]),
+				}
+			},
+			texts: []string{"fragmented"},
+		},
+		{
+			name:   "message larger than a read buffer",
+			frames: func() []ws.Frame { return []ws.Frame{ws.NewTextFrame(echoMessage(0, strings.Repeat("x", 64*1024)))} },
+			texts:  []string{strings.Repeat("x", 64*1024)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, url := newNetpollTestServer(t)
+			conn := dialTestClient(t, url)
+
+			var batch []byte
+			for _, frame := range tt.frames() {
+				frame.Payload = append([]byte(nil), frame.Payload...)
+				var buf strings.Builder
+				if err := ws.WriteFrame(&buf, ws.MaskFrameInPlace(frame)); err != nil {
+					t.Fatal(err)
+				}
+				batch = append(batch, buf.String()...)
+			}
+			if _, err := conn.Write(batch); err != nil {
+				t.Fatal(err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i, text := range tt.texts {
+				data, err := wsutil.ReadServerText(conn)
+				if err != nil {
+					t.Fatalf("reply %d: %v", i, err)
+				}
+				var msg Message
+				if err := json.Unmarshal(data, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.ReplyTo != fmt.Sprint(i) || msg.Payload["text"] != text {
+					t.Fatalf("reply %d: got %s replying to %s", i, msg.Event, msg.ReplyTo)
+				}
+			}
+		})
+	}
+}
+
+func TestNetpollIdleClientsHoldNoGoroutine(t *testing.T) {
+
+	_, url := newNetpollTestServer(t)
+
+	// lets the goroutines of the previous tests and of the server settle
+	settled := func() int {
+		count := runtime.NumGoroutine()
+		for i := 0; i < 50; i++ {
+			time.Sleep(20 * time.Millisecond)
+			current := runtime.NumGoroutine()
+			if current == count {
+				return count
+			}
+			count = current
+		}
+		return count
+	}
+
+	// the first client opens the default group along with its goroutines
+	dialTestClient(t, url)
+	before := settled()
+
+	const count = 50
+	conns := make([]net.Conn, 0, count)
+	for i := 0; i < count; i++ {
+		conns = append(conns, dialTestClient(t, url))
+	}
+	after := settled()
+
+	if after-before >= count/2 {
+		t.Fatalf("%d goroutines for %d idle clients", after-before, count)
+	}
+
+	// every idle client is still served
+	for i, conn := range conns {
+		if err := wsutil.WriteClientText(conn, echoMessage(i, "awake")); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := wsutil.ReadServerText(conn); err != nil {
+			t.Fatalf("client %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+var errNetpollUnsupported = errors.New("netpoll is only supported on linux")
+
+// netpoll is only available on linux, every connection is read by a goroutine of its own elsewhere
+type netpoll struct{}
+
+type polledConn struct{}
+
+func newNetpoll(pool *workerPool) (*netpoll, error) {
+	return nil, errNetpollUnsupported
+}
+
+func (np *netpoll) register(conn net.Conn, onReadable func() bool) *polledConn {
+	return nil
+}
+
+func (pc *polledConn) arm() error {
+	return errNetpollUnsupported
+}
+
+func (pc *polledConn) unwatch() {}
+
+func (np *netpoll) close() error {
+	return nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+const (
+
+	// the oldest queued message is discarded to make room for the new one
+	SLOW_CONSUMER_POLICY_DROP_OLDEST = "drop_oldest"
+
+	// the new message is discarded
+	SLOW_CONSUMER_POLICY_DROP_NEWEST = "drop_newest"
+
+	// the client is disconnected
+	SLOW_CONSUMER_POLICY_DISCONNECT = "disconnect"
+
+)
+
+// what happens to a message pushed to a client whose outbound queue is full
+type SlowConsumerPolicy string
+
+// a message waiting to be written to a client, either data of its own or a frame prepared for several clients
+type outboundMessage struct {
+	data     []byte
+	opCode   ws.OpCode
+	prepared *preparedMessage
+}
+
+// bounded queue of the messages pushed to a client, so that pushing never waits for the client to read
+type outboundQueue struct {
+	lock     sync.Mutex
+	messages []outboundMessage
+	limit    int
+	policy   SlowConsumerPolicy
+
+	// whether a writer is draining the queue, whether the queue overflowed since it was last empty and
+	// whether the client is closed
+	writing     bool
+	overflowing bool
+	closed      bool
+
+	// set once a write failed, possibly in the middle of a frame after which nothing can be written anymore
+	broken bool
+
+	// set once the client is closing normally: no message is queued anymore while the writer writes the
+	// queued ones, closing drained once it stops
+	closing bool
+	drained chan struct{}
+
+	// called once per overflow of the queue, and when a write to the client fails
+	onSlowConsumer func(policy SlowConsumerPolicy)
+	onWriteError   func(err error)
+}
+
+func newOutboundQueue(limit int, policy SlowConsumerPolicy) *outboundQueue {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &outboundQueue{limit: limit, policy: policy}
+}
+
+// queues the message and starts a writer unless one is already draining the queue. When the queue is full
+// the slow consumer policy decides which message is discarded, or whether the client is disconnected
+func (cl *socketClient) enqueue(msg outboundMessage) error {
+
+	q := cl.outbound
+
+	// a client without queue is written to by the caller
+	if q == nil {
+		return cl.writeMessage(msg)
+	}
+
+	q.lock.Lock()
+
+	if q.closed || q.closing {
+		q.lock.Unlock()
+		return ErrClientClosed
+	}
+
+	var err error
+	overflowed := false
+
+	if len(q.messages) >= q.limit {
+		overflowed = !q.overflowing
+		q.overflowing = true
+
+		switch q.policy {
+		case SLOW_CONSUMER_POLICY_DROP_OLDEST:
+			q.messages[0] = outboundMessage{}
+			q.messages = q.messages[1:]
+		case SLOW_CONSUMER_POLICY_DROP_NEWEST:
+			err = ErrSlowConsumer
+		default:
+			// nothing is written to the client anymore but the close frame
+			q.closed = true
+			q.messages = nil
+			err = ErrSlowConsumer
+		}
+	}
+
+	if err == nil {
+		q.messages = append(q.messages, msg)
+	}
+
+	startWriter := !q.closed && !q.writing && len(q.messages) > 0
+	if startWriter {
+		q.writing = true
+	}
+	q.lock.Unlock()
+
+	if overflowed && q.onSlowConsumer != nil {
+		q.onSlowConsumer(q.policy)
+	}
+	if startWriter {
+		go cl.drainOutbound()
+	}
+	return err
+}
+
+// writes the queued messages in order, the writer stops once the queue is empty so that an idle client
+// holds no goroutine
+func (cl *socketClient) drainOutbound() {
+
+	q := cl.outbound
+
+	for {
+		q.lock.Lock()
+		if q.closed || len(q.messages) == 0 {
+			q.writing = false
+			q.overflowing = false
+			if q.drained != nil {
+				close(q.drained)
+				q.drained = nil
+			}
+			q.lock.Unlock()
+			return
+		}
+		msg := q.messages[0]
+		q.messages[0] = outboundMessage{}
+		q.messages = q.messages[1:]
+		q.lock.Unlock()
+
+		if err := cl.writeMessage(msg); err != nil {
+			q.lock.Lock()
+			q.broken = true
+			q.lock.Unlock()
+
+			q.close()
+			if q.onWriteError != nil {
+				q.onWriteError(err)
+			}
+		}
+	}
+}
+
+// writes a single message, giving up once the write timeout of the client elapses
+func (cl *socketClient) writeMessage(msg outboundMessage) error {
+	cl.writeLock.Lock()
+	defer cl.writeLock.Unlock()
+
+	conn := *cl.socket
+	if cl.writeTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(cl.writeTimeout)); err != nil {
+			return err
+		}
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+
+	if msg.prepared != nil {
+		return cl.writePrepared(msg.prepared)
+	}
+	return cl.writeData(msg.data, msg.opCode)
+}
+
+// refuses the following messages and waits for the writer to write the queued ones, for at most the
+// timeout, then discards whatever is left. Nothing is left to write once a write failed or the queue was
+// discarded for a slow consumer
+func (q *outboundQueue) flush(timeout time.Duration) {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	q.closing = true
+	if !q.writing {
+		q.lock.Unlock()
+		q.close()
+		return
+	}
+	if q.drained == nil {
+		q.drained = make(chan struct{})
+	}
+	drained := q.drained
+	q.lock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+	case <-timer.C:
+	}
+	q.close()
+}
+
+// discards the queued messages and refuses the following ones
+func (q *outboundQueue) close() {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	q.closed = true
+	q.messages = nil
+	q.lock.Unlock()
+}
+
+// whether frames can still be written to the client, false once a write failed
+func (q *outboundQueue) writable() bool {
+	if q == nil {
+		return true
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return !q.broken
+}
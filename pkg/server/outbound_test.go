@@ -0,0 +1,244 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// a client with an outbound queue writing to one end of a pipe, the test reading the other end
+func newOutboundClient(t *testing.T, limit int, policy SlowConsumerPolicy) (*socketClient, net.Conn) {
+
+	server, peer := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		peer.Close()
+	})
+
+	cl := newSocketClient("client", &server, &JsonEncoder{})
+	cl.outbound = newOutboundQueue(limit, policy)
+	cl.writeTimeout = 5 * time.Second
+	return cl, peer
+}
+
+// waits for the writer to take the queued messages out of the queue
+func waitQueueEmpty(t *testing.T, q *outboundQueue) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		q.lock.Lock()
+		empty := len(q.messages) == 0
+		q.lock.Unlock()
+		if empty {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("writer did not take the queued messages")
+}
+
+// reads the frames written to the peer until none arrives within the wait
+func readFrames(peer net.Conn, wait time.Duration) []ws.Frame {
+	var frames []ws.Frame
+	for {
+		peer.SetReadDeadline(time.Now().Add(wait))
+		frame, err := ws.ReadFrame(peer)
+		if err != nil {
+			return frames
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestOutboundQueuePolicies(t *testing.T) {
+
+	tests := []struct {
+		policy   SlowConsumerPolicy
+		errs     []error
+		received string
+	}{
+		{SLOW_CONSUMER_POLICY_DROP_OLDEST, []error{nil, nil, nil, nil}, "145"},
+		{SLOW_CONSUMER_POLICY_DROP_NEWEST, []error{nil, nil, ErrSlowConsumer, ErrSlowConsumer}, "123"},
+		{SLOW_CONSUMER_POLICY_DISCONNECT, []error{nil, nil, ErrSlowConsumer, ErrClientClosed}, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+
+			cl, peer := newOutboundClient(t, 2, tt.policy)
+
+			var lock sync.Mutex
+			var overflows []SlowConsumerPolicy
+			cl.outbound.onSlowConsumer = func(policy SlowConsumerPolicy) {
+				lock.Lock()
+				overflows = append(overflows, policy)
+				lock.Unlock()
+			}
+
+			// the writer is blocked writing the first message as long as the peer does not read
+			if err := cl.PushData([]byte("1"), ws.OpText); err != nil {
+				t.Fatal(err)
+			}
+			waitQueueEmpty(t, cl.outbound)
+
+			for i, want := range tt.errs {
+				if err := cl.PushData([]byte{byte('2' + i)}, ws.OpText); err != want {
+					t.Fatalf("push %d: got %v, want %v", i+2, err, want)
+				}
+			}
+
+			var received strings.Builder
+			for _, frame := range readFrames(peer, 200*time.Millisecond) {
+				received.Write(frame.Payload)
+			}
+			if received.String() != tt.received {
+				t.Fatalf("received %q, want %q", received.String(), tt.received)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if len(overflows) != 1 || overflows[0] != tt.policy {
+				t.Fatalf("got slow consumer calls %v, want one with %s", overflows, tt.policy)
+			}
+		})
+	}
+}
+
+func TestOutboundQueueWrittenBeforeClose(t *testing.T) {
+
+	cl, peer := newOutboundClient(t, 10, SLOW_CONSUMER_POLICY_DISCONNECT)
+
+	for _, data := range []string{"1", "2", "3"} {
+		if err := cl.PushData([]byte(data), ws.OpText); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- cl.closeWithStatus(ws.StatusGoingAway, "bye") }()
+
+	frames := readFrames(peer, 500*time.Millisecond)
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want the 3 messages and the close frame", len(frames))
+	}
+	for i, want := range []string{"1", "2", "3"} {
+		if frames[i].Header.OpCode != ws.OpText || string(frames[i].Payload) != want {
+			t.Fatalf("frame %d: got %v %q, want %q", i, frames[i].Header.OpCode, frames[i].Payload, want)
+		}
+	}
+	if code, _ := ws.ParseCloseFrameData(frames[3].Payload); frames[3].Header.OpCode != ws.OpClose || code != ws.StatusGoingAway {
+		t.Fatalf("got %v with status %d, want a close frame with %d", frames[3].Header.OpCode, code, ws.StatusGoingAway)
+	}
+
+	if err := <-closed; err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := cl.PushData([]byte("4"), ws.OpText); err != ErrClientClosed {
+		t.Fatalf("push after close: got %v, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestOutboundQueueCloseWithinWriteTimeout(t *testing.T) {
+
+	cl, _ := newOutboundClient(t, 10, SLOW_CONSUMER_POLICY_DISCONNECT)
+	cl.writeTimeout = 100 * time.Millisecond
+
+	// the peer never reads
+	for _, data := range []string{"1", "2"} {
+		if err := cl.PushData([]byte(data), ws.OpText); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	cl.closeWithStatus(ws.StatusGoingAway, "bye")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("close took %v", elapsed)
+	}
+}
+
+// server whose flood event pushes large messages to a client until pushing fails
+func newFloodServer(t *testing.T, cfg *Configuration) (*SocketServer, string) {
+
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+
+	payload := map[string]interface{}{"data": strings.Repeat("x", 256*1024)}
+	ss.On("flood", func(ctx *EventContext) error {
+		for i := 0; i < 400; i++ {
+			if err := ctx.Emit("data", payload); err != nil && err != ErrSlowConsumer {
+				return nil
+			}
+		}
+		return nil
+	})
+
+	srv := httptest.NewServer(ss)
+	t.Cleanup(srv.Close)
+	return ss, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestSlowConsumerDisconnected(t *testing.T) {
+
+	ss, url := newFloodServer(t, DefaultServerConfiguration(":0").SetOutboundQueueSize(4).SetWriteTimeout(200*time.Millisecond))
+
+	slow := make(chan SlowConsumerPolicy, 1)
+	ss.OnSlowConsumer = func(clientId string, policy SlowConsumerPolicy) { slow <- policy }
+	disconnected := make(chan error, 1)
+	ss.OnClientDisconnected = func(clientId string, err error) { disconnected <- err }
+
+	// the client never reads what it is sent
+	conn := dialTestClient(t, url)
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"flood","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case policy := <-slow:
+		if policy != SLOW_CONSUMER_POLICY_DISCONNECT {
+			t.Fatalf("got policy %s", policy)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("slow consumer not reported")
+	}
+
+	select {
+	case err := <-disconnected:
+		if err != ErrSlowConsumer {
+			t.Fatalf("disconnected with %v, want %v", err, ErrSlowConsumer)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("slow consumer not disconnected")
+	}
+}
+
+func TestClientReapedOnWriteTimeout(t *testing.T) {
+
+	cfg := DefaultServerConfiguration(":0").SetOutboundQueueSize(1000).SetSlowConsumerPolicy(SLOW_CONSUMER_POLICY_DROP_NEWEST).
+		SetWriteTimeout(200 * time.Millisecond)
+	ss, url := newFloodServer(t, cfg)
+
+	disconnected := make(chan error, 1)
+	ss.OnClientDisconnected = func(clientId string, err error) { disconnected <- err }
+
+	conn := dialTestClient(t, url)
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"flood","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-disconnected:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("disconnected with %v, want a write timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("client not reaped")
+	}
+}
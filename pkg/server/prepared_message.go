@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/gobwas/ws"
+)
+
+// a message encoded and framed once, whose frame bytes are written as they are to every client sharing
+// its encoder
+type preparedMessage struct {
+	opCode  ws.OpCode
+	payload []byte
+
+	// uncompressed frame
+	frame []byte
+
+	// frame compressed without context for the clients not keeping one, built on first need
+	compressOnce sync.Once
+	compressed   []byte
+}
+
+func newPreparedMessage(payload []byte, opCode ws.OpCode) (*preparedMessage, error) {
+
+	frame, err := buildFrame(opCode, payload, false)
+	if err != nil {
+		return nil, err
+	}
+	return &preparedMessage{opCode: opCode, payload: payload, frame: frame}, nil
+}
+
+// the frame compressed at the given level, nil when it could not be compressed
+func (pm *preparedMessage) compressedFrame(level int) []byte {
+
+	pm.compressOnce.Do(func() {
+
+		deflated, err := compressMessage(pm.payload, level)
+		if err != nil {
+			AppLogger.Errorf("[compressedFrame] error occurred while compressing message: %v", err)
+			return
+		}
+
+		if pm.compressed, err = buildFrame(pm.opCode, deflated, true); err != nil {
+			AppLogger.Errorf("[compressedFrame] error occurred while framing compressed message: %v", err)
+		}
+	})
+	return pm.compressed
+}
+
+// serializes a single unmasked server frame, flagged with the compression bit when its payload is compressed
+func buildFrame(opCode ws.OpCode, payload []byte, compressed bool) ([]byte, error) {
+
+	frame := ws.NewFrame(opCode, true, payload)
+	if compressed {
+		frame.Header.Rsv = ws.Rsv(true, false, false)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(ws.HeaderSize(frame.Header) + len(payload))
+	if err := ws.WriteFrame(&buf, frame); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/gobwas/ws"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+
+	// payload key under which the raw_payload of a protobuf Message is exposed to the handlers
+	RawPayloadKey = "raw"
+
+)
+
+// field numbers of the Message envelope published in proto/message.proto
+const (
+	protoFieldEvent      protowire.Number = 1
+	protoFieldId         protowire.Number = 2
+	protoFieldReplyTo    protowire.Number = 3
+	protoFieldPayload    protowire.Number = 4
+	protoFieldRawPayload protowire.Number = 5
+)
+
+// encodes messages as the protobuf Message envelope of proto/message.proto. The envelope is small enough
+// to be read and written field by field, only the payload Struct goes through the protobuf runtime
+type ProtobufEncoder struct {
+}
+
+// decodes the byte array into Application specific Message format
+func (pe *ProtobufEncoder) Decode(data []byte) (*Message, error) {
+
+	var res Message
+
+	for len(data) > 0 {
+
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, pe.decodeError(protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		switch {
+		case num == protoFieldEvent && typ == protowire.BytesType:
+			res.Event, n = protowire.ConsumeString(data)
+
+		case num == protoFieldId && typ == protowire.BytesType:
+			res.Id, n = protowire.ConsumeString(data)
+
+		case num == protoFieldReplyTo && typ == protowire.BytesType:
+			res.ReplyTo, n = protowire.ConsumeString(data)
+
+		case num == protoFieldPayload && typ == protowire.BytesType:
+			var raw []byte
+			raw, n = protowire.ConsumeBytes(data)
+			if n >= 0 {
+				payload := &structpb.Struct{}
+				if err := proto.Unmarshal(raw, payload); err != nil {
+					return nil, pe.decodeError(err)
+				}
+				res.Payload = payload.AsMap()
+			}
+
+		case num == protoFieldRawPayload && typ == protowire.BytesType:
+			var raw []byte
+			raw, n = protowire.ConsumeBytes(data)
+			if n >= 0 {
+				res.Payload = map[string]interface{}{RawPayloadKey: append([]byte(nil), raw...)}
+			}
+
+		default:
+			// fields added to the envelope later on are skipped
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+
+		if n < 0 {
+			return nil, pe.decodeError(protowire.ParseError(n))
+		}
+		data = data[n:]
+	}
+
+	return &res, nil
+}
+
+func (pe *ProtobufEncoder) decodeError(err error) error {
+	AppLogger.Errorln("[Decode] error occurred while unmarshalling protobuf to Message: ", err)
+	return err
+}
+
+// encodes the application specific Message format into byte array
+func (pe *ProtobufEncoder) Encode(data Message) []byte {
+
+	var res []byte
+
+	if data.Event != "" {
+		res = protowire.AppendTag(res, protoFieldEvent, protowire.BytesType)
+		res = protowire.AppendString(res, data.Event)
+	}
+	if data.Id != "" {
+		res = protowire.AppendTag(res, protoFieldId, protowire.BytesType)
+		res = protowire.AppendString(res, data.Id)
+	}
+	if data.ReplyTo != "" {
+		res = protowire.AppendTag(res, protoFieldReplyTo, protowire.BytesType)
+		res = protowire.AppendString(res, data.ReplyTo)
+	}
+
+	if raw, ok := rawPayload(data.Payload); ok {
+		res = protowire.AppendTag(res, protoFieldRawPayload, protowire.BytesType)
+		res = protowire.AppendBytes(res, raw)
+		return res
+	}
+
+	if data.Payload != nil {
+		payload, err := payloadStruct(data.Payload)
+		if err != nil {
+			AppLogger.Errorln("[Encode] error occurred while converting payload to protobuf Struct: ", err)
+			return nil
+		}
+		raw, err := proto.Marshal(payload)
+		if err != nil {
+			AppLogger.Errorln("[Encode] error occurred while marshalling Message to protobuf: ", err)
+			return nil
+		}
+		res = protowire.AppendTag(res, protoFieldPayload, protowire.BytesType)
+		res = protowire.AppendBytes(res, raw)
+	}
+
+	// an empty message still has to be a non nil encoding
+	if res == nil {
+		res = []byte{}
+	}
+	return res
+}
+
+// converts the payload to a protobuf Struct. structpb only takes the generic JSON types, so a payload holding
+// values such as []string, typed maps or structs is first given the shape it has for the JSON clients
+func payloadStruct(payload map[string]interface{}) (*structpb.Struct, error) {
+
+	converted, err := structpb.NewStruct(payload)
+	if err == nil {
+		return converted, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(generic)
+}
+
+// a payload only made of bytes under RawPayloadKey travels as the raw_payload of the envelope
+func rawPayload(payload map[string]interface{}) ([]byte, bool) {
+
+	if len(payload) != 1 {
+		return nil, false
+	}
+	raw, ok := payload[RawPayloadKey].([]byte)
+	return raw, ok
+}
+
+// protobuf is a binary format
+func (pe *ProtobufEncoder) OpCode() ws.OpCode {
+	return ws.OpBinary
+}
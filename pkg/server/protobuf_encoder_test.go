@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProtobufEncoderRoundTrip(t *testing.T) {
+
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "generic values",
+			payload: map[string]interface{}{"text": "hi", "count": 3, "ok": true, "list": []interface{}{"a", 1.5}},
+			want:    map[string]interface{}{"text": "hi", "count": float64(3), "ok": true, "list": []interface{}{"a", 1.5}},
+		},
+		{
+			name:    "string slice",
+			payload: map[string]interface{}{"tags": []string{"a", "b"}},
+			want:    map[string]interface{}{"tags": []interface{}{"a", "b"}},
+		},
+		{
+			name:    "slice of maps",
+			payload: map[string]interface{}{"items": []map[string]interface{}{{"id": 1}}},
+			want:    map[string]interface{}{"items": []interface{}{map[string]interface{}{"id": float64(1)}}},
+		},
+		{
+			name:    "typed map and struct",
+			payload: map[string]interface{}{"labels": map[string]string{"k": "v"}, "at": point{1, 2}},
+			want:    map[string]interface{}{"labels": map[string]interface{}{"k": "v"}, "at": map[string]interface{}{"x": float64(1), "y": float64(2)}},
+		},
+		{
+			name:    "time",
+			payload: map[string]interface{}{"at": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+			want:    map[string]interface{}{"at": "2020-01-02T03:04:05Z"},
+		},
+	}
+
+	encoder := &ProtobufEncoder{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			data := encoder.Encode(Message{Event: "event", Id: "id", Payload: tt.payload})
+			if data == nil {
+				t.Fatal("message not encoded")
+			}
+
+			decoded, err := encoder.Decode(data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if decoded.Event != "event" || decoded.Id != "id" {
+				t.Fatalf("got event: %q id: %q", decoded.Event, decoded.Id)
+			}
+			if !reflect.DeepEqual(decoded.Payload, tt.want) {
+				t.Fatalf("got payload %#v, want %#v", decoded.Payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtobufEncoderRawPayload(t *testing.T) {
+
+	encoder := &ProtobufEncoder{}
+	raw := []byte{0, 1, 2, 255}
+
+	decoded, err := encoder.Decode(encoder.Encode(Message{Event: "blob", Payload: map[string]interface{}{RawPayloadKey: raw}}))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got, _ := decoded.Payload[RawPayloadKey].([]byte); !bytes.Equal(got, raw) {
+		t.Fatalf("got raw payload %v, want %v", decoded.Payload[RawPayloadKey], raw)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"unicode/utf8"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// returned by the reader once the close handshake initiated by the client has been answered
+var errCloseReceived = errors.New("close frame received")
+
+// a close frame body must hold at least the two bytes of a status code
+var errCloseFrameTooShort = ws.ProtocolError("close frame payload is too short")
+
+// returned when a compressed message is not valid deflate data
+var errInvalidCompressedData = errors.New("compressed message is invalid")
+
+// reads the data messages of a client, reassembling fragmented messages and handling the control
+// frames received before or in between their fragments
+type messageReader struct {
+	client *socketClient
+	reader *wsutil.Reader
+
+	// maximum size of a message, 0 when unlimited
+	maxMessageSize int64
+
+	// op code of the last message read, text or binary
+	opCode ws.OpCode
+}
+
+func newMessageReader(conn net.Conn, client *socketClient, cfg *Configuration) *messageReader {
+
+	// a single frame can't be larger than the message it belongs to
+	maxFrameSize := cfg.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = cfg.MaxMessageSize
+	}
+
+	mr := &messageReader{client: client, maxMessageSize: cfg.MaxMessageSize}
+	mr.reader = &wsutil.Reader{
+		Source:       conn,
+		State:        ws.StateServerSide,
+		CheckUTF8:    true,
+		MaxFrameSize: maxFrameSize,
+		OnIntermediate: func(header ws.Header, frame io.Reader) error {
+			return mr.handleControlFrame(header, frame)
+		},
+	}
+
+	// the compression bit is allowed once the extension is negotiated, and text is only checked
+	// for valid UTF-8 once decompressed
+	if client.compression != nil {
+		mr.reader.State |= ws.StateExtended
+		mr.reader.Extensions = []wsutil.RecvExtension{client.compression}
+		mr.reader.CheckUTF8 = false
+	}
+	return mr
+}
+
+// reads the next frame of the client. A control frame is handled on its own, while a data frame is read
+// along with the rest of its message, whose payload is returned as complete
+func (mr *messageReader) step() ([]byte, bool, error) {
+
+	header, err := mr.reader.NextFrame()
+	if err == wsutil.ErrFrameTooLarge {
+		return nil, false, ErrFrameTooLarge
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if header.OpCode.IsControl() {
+		return nil, false, mr.handleControlFrame(header, mr.reader)
+	}
+
+	// any frame proves the client is alive
+	mr.client.markSeen()
+	mr.opCode = header.OpCode
+
+	// the continuation frames are read by the reader until the final one
+	payload, err := readLimited(mr.reader, mr.maxMessageSize)
+	if err == wsutil.ErrFrameTooLarge {
+		return nil, false, ErrFrameTooLarge
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if compression := mr.client.compression; compression != nil {
+		if compression.state.IsCompressed() {
+			if payload, err = compression.decompress(payload, mr.maxMessageSize); err != nil {
+				if err == ErrMessageTooLarge {
+					return nil, false, err
+				}
+				return nil, false, fmt.Errorf("%w: %v", errInvalidCompressedData, err)
+			}
+		}
+		if header.OpCode == ws.OpText && !utf8.Valid(payload) {
+			return nil, false, wsutil.ErrInvalidUTF8
+		}
+	}
+	return payload, true, nil
+}
+
+// reads the whole message without ever holding more than limit bytes of it, 0 reads it whatever its size
+func readLimited(message io.Reader, limit int64) ([]byte, error) {
+
+	if limit <= 0 {
+		return ioutil.ReadAll(message)
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(message, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(payload)) > limit {
+		return nil, ErrMessageTooLarge
+	}
+	return payload, nil
+}
+
+func (mr *messageReader) handleControlFrame(header ws.Header, frame io.Reader) error {
+
+	// any frame proves the client is alive
+	mr.client.markSeen()
+
+	payload, err := ioutil.ReadAll(frame)
+	if err != nil {
+		return err
+	}
+
+	switch header.OpCode {
+
+	case ws.OpPing:
+		if err := mr.client.PushData(payload, ws.OpPong); err != nil {
+			AppLogger.Errorf("[handleControlFrame] client: %s error occurred while sending pong: %v", mr.client.Id, err)
+		}
+		return nil
+
+	case ws.OpPong:
+		return nil
+
+	case ws.OpClose:
+		return mr.answerClose(payload)
+	}
+	return nil
+}
+
+// answers the close frame of the client by echoing its status code, or with a protocol error
+// when the close frame is malformed
+func (mr *messageReader) answerClose(payload []byte) error {
+
+	// a close frame without a body carries no status code to echo
+	if len(payload) == 0 {
+		mr.client.closeWithBody(nil)
+		return errCloseReceived
+	}
+	if len(payload) == 1 {
+		return errCloseFrameTooShort
+	}
+
+	code, reason := ws.ParseCloseFrameData(payload)
+	if err := ws.CheckCloseFrameData(code, reason); err != nil {
+		return err
+	}
+
+	mr.client.closeWithStatus(code, "")
+	return errCloseReceived
+}
+
+// the status code the connection is closed with after the read error, zero when no close frame
+// can be sent anymore
+func readErrorStatus(err error) ws.StatusCode {
+
+	var protocolErr ws.ProtocolError
+	switch {
+	case errors.Is(err, wsflate.ErrUnexpectedCompressionBit), errors.As(err, &protocolErr):
+		return ws.StatusProtocolError
+	case errors.Is(err, wsutil.ErrInvalidUTF8), errors.Is(err, errInvalidCompressedData):
+		return ws.StatusInvalidFramePayloadData
+	case err == ErrMessageTooLarge, err == ErrFrameTooLarge:
+		return ws.StatusMessageTooBig
+	}
+	return 0
+}
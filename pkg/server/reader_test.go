@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// a reader over one end of a pipe, along with the frames the server writes back on the other end
+type readerFixture struct {
+	reader  *messageReader
+	client  net.Conn
+	written chan ws.Frame
+}
+
+func newReaderFixture(t *testing.T, cfg *Configuration, compressed bool) *readerFixture {
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	cl := newSocketClient("client", &server, &JsonEncoder{})
+	if compressed {
+		cl.compression = newCompression(wsflate.Parameters{}, cfg)
+	}
+
+	fixture := &readerFixture{reader: newMessageReader(server, cl, cfg), client: client, written: make(chan ws.Frame, 16)}
+	go func() {
+		for {
+			frame, err := ws.ReadFrame(client)
+			if err != nil {
+				close(fixture.written)
+				return
+			}
+			fixture.written <- frame
+		}
+	}()
+	return fixture
+}
+
+// sends the frames masked, as a client does, from a goroutine since the pipe is synchronous
+func (rf *readerFixture) send(frames ...ws.Frame) {
+	go func() {
+		for _, frame := range frames {
+			var buf bytes.Buffer
+			frame.Payload = append([]byte(nil), frame.Payload...)
+			if err := ws.WriteFrame(&buf, ws.MaskFrameInPlace(frame)); err != nil {
+				return
+			}
+			if _, err := rf.client.Write(buf.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// reads messages until one is complete or an error occurs
+func (rf *readerFixture) next() ([]byte, error) {
+	for {
+		payload, complete, err := rf.reader.step()
+		if err != nil || complete {
+			return payload, err
+		}
+	}
+}
+
+func withRsv1(frame ws.Frame) ws.Frame {
+	frame.Header.Rsv = ws.Rsv(true, false, false)
+	return frame
+}
+
+func compressedText(t *testing.T, text string) ws.Frame {
+	data, err := compressMessage([]byte(text), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withRsv1(ws.NewTextFrame(data))
+}
+
+func TestReaderMessages(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		compressed bool
+		frames     func(t *testing.T) []ws.Frame
+		want       string
+		opCode     ws.OpCode
+	}{
+		{
+			name:   "text",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewTextFrame([]byte("hello"))} },
+			want:   "hello",
+			opCode: ws.OpText,
+		},
+		{
+			name:   "binary",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewBinaryFrame([]byte{0xff, 0})} },
+			want:   "\xff\x00",
+			opCode: ws.OpBinary,
+		},
+		{
+			name: "fragments around a ping",
+			frames: func(t *testing.T) []ws.Frame {
+				return []ws.Frame{
+					ws.NewFrame(ws.OpText, false, []byte("hel")),
+					ws.NewPingFrame([]byte("ping")),
+					ws.NewFrame(ws.OpContinuation, true, []byte("lo")),
+				}
+			},
+			want:   "hello",
+			opCode: ws.OpText,
+		},
+		{
+			name:       "compressed text",
+			compressed: true,
+			frames:     func(t *testing.T) []ws.Frame { return []ws.Frame{compressedText(t, "hello hello hello")} },
+			want:       "hello hello hello",
+			opCode:     ws.OpText,
+		},
+		{
+			name:       "uncompressed text with compression negotiated",
+			compressed: true,
+			frames:     func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewTextFrame([]byte("plain"))} },
+			want:       "plain",
+			opCode:     ws.OpText,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fixture := newReaderFixture(t, DefaultServerConfiguration(":0"), tt.compressed)
+			fixture.send(tt.frames(t)...)
+
+			payload, err := fixture.next()
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(payload) != tt.want {
+				t.Fatalf("got %q, want %q", payload, tt.want)
+			}
+			if fixture.reader.opCode != tt.opCode {
+				t.Fatalf("got op code %v, want %v", fixture.reader.opCode, tt.opCode)
+			}
+		})
+	}
+}
+
+func TestReaderAnswersPing(t *testing.T) {
+
+	fixture := newReaderFixture(t, DefaultServerConfiguration(":0"), false)
+	fixture.send(ws.NewPingFrame([]byte("ping")), ws.NewTextFrame([]byte("done")))
+
+	if _, err := fixture.next(); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	select {
+	case frame := <-fixture.written:
+		if frame.Header.OpCode != ws.OpPong || string(frame.Payload) != "ping" {
+			t.Fatalf("got %v %q, want a pong echoing the ping", frame.Header.OpCode, frame.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ping not answered")
+	}
+}
+
+func TestReaderErrorStatus(t *testing.T) {
+
+	limited := DefaultServerConfiguration(":0").SetMaxMessageSize(8).SetMaxFrameSize(4)
+
+	tests := []struct {
+		name       string
+		cfg        *Configuration
+		compressed bool
+		frames     func(t *testing.T) []ws.Frame
+		want       ws.StatusCode
+	}{
+		{
+			name:   "invalid UTF-8 text",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewTextFrame([]byte{0xc3, 0x28})} },
+			want:   ws.StatusInvalidFramePayloadData,
+		},
+		{
+			name:       "invalid UTF-8 compressed text",
+			compressed: true,
+			frames:     func(t *testing.T) []ws.Frame { return []ws.Frame{compressedText(t, "\xc3\x28")} },
+			want:       ws.StatusInvalidFramePayloadData,
+		},
+		{
+			name:       "corrupt compressed data",
+			compressed: true,
+			frames:     func(t *testing.T) []ws.Frame { return []ws.Frame{withRsv1(ws.NewTextFrame([]byte("garbage")))} },
+			want:       ws.StatusInvalidFramePayloadData,
+		},
+		{
+			name:   "compression bit without compression",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{withRsv1(ws.NewTextFrame([]byte("text")))} },
+			want:   ws.StatusProtocolError,
+		},
+		{
+			name:       "compression bit on a continuation frame",
+			compressed: true,
+			frames: func(t *testing.T) []ws.Frame {
+				return []ws.Frame{
+					ws.NewFrame(ws.OpText, false, []byte("a")),
+					withRsv1(ws.NewFrame(ws.OpContinuation, true, []byte("b"))),
+				}
+			},
+			want: ws.StatusProtocolError,
+		},
+		{
+			name:       "compression bit on a control frame",
+			compressed: true,
+			frames:     func(t *testing.T) []ws.Frame { return []ws.Frame{withRsv1(ws.NewPingFrame(nil))} },
+			want:       ws.StatusProtocolError,
+		},
+		{
+			name:   "reserved op code",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewFrame(ws.OpCode(3), true, nil)} },
+			want:   ws.StatusProtocolError,
+		},
+		{
+			name:   "fragmented control frame",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewFrame(ws.OpPing, false, nil)} },
+			want:   ws.StatusProtocolError,
+		},
+		{
+			name:   "unexpected continuation frame",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewFrame(ws.OpContinuation, true, []byte("a"))} },
+			want:   ws.StatusProtocolError,
+		},
+		{
+			name: "data frame within a fragmented message",
+			frames: func(t *testing.T) []ws.Frame {
+				return []ws.Frame{ws.NewFrame(ws.OpText, false, []byte("a")), ws.NewTextFrame([]byte("b"))}
+			},
+			want: ws.StatusProtocolError,
+		},
+		{
+			name:   "one byte close payload",
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewFrame(ws.OpClose, true, []byte{0x03})} },
+			want:   ws.StatusProtocolError,
+		},
+		{
+			name: "close status code not in use",
+			frames: func(t *testing.T) []ws.Frame {
+				return []ws.Frame{ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNoStatusRcvd, ""))}
+			},
+			want: ws.StatusProtocolError,
+		},
+		{
+			name:   "frame larger than MaxFrameSize",
+			cfg:    limited,
+			frames: func(t *testing.T) []ws.Frame { return []ws.Frame{ws.NewTextFrame([]byte("12345"))} },
+			want:   ws.StatusMessageTooBig,
+		},
+		{
+			name: "message larger than MaxMessageSize",
+			cfg:  limited,
+			frames: func(t *testing.T) []ws.Frame {
+				return []ws.Frame{
+					ws.NewFrame(ws.OpText, false, []byte("1234")),
+					ws.NewFrame(ws.OpContinuation, false, []byte("1234")),
+					ws.NewFrame(ws.OpContinuation, true, []byte("1")),
+				}
+			},
+			want: ws.StatusMessageTooBig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cfg := tt.cfg
+			if cfg == nil {
+				cfg = DefaultServerConfiguration(":0")
+			}
+			fixture := newReaderFixture(t, cfg, tt.compressed)
+			fixture.send(tt.frames(t)...)
+
+			_, err := fixture.next()
+			if err == nil {
+				t.Fatal("expected a read error")
+			}
+			if got := readErrorStatus(err); got != tt.want {
+				t.Fatalf("got status %d for %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderEchoesClose(t *testing.T) {
+
+	fixture := newReaderFixture(t, DefaultServerConfiguration(":0"), false)
+	fixture.send(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusGoingAway, "bye")))
+
+	if _, err := fixture.next(); err != errCloseReceived {
+		t.Fatalf("got %v, want %v", err, errCloseReceived)
+	}
+
+	select {
+	case frame := <-fixture.written:
+		code, _ := ws.ParseCloseFrameData(frame.Payload)
+		if frame.Header.OpCode != ws.OpClose || code != ws.StatusGoingAway {
+			t.Fatalf("got %v with status %d, want a close echoing %d", frame.Header.OpCode, code, ws.StatusGoingAway)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close not answered")
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sync"
+)
+
+// Broker backed by redis pub/sub, messages published while a node is not subscribed are lost for it
+type RedisBroker struct {
+	sync.Mutex
+
+	client			*redis.Client
+
+	// buffer size of every subscription channel
+	bufferSize		int64
+
+	subscriptions	map[string]*redisSubscription
+}
+
+type redisSubscription struct {
+	pubSub	*redis.PubSub
+
+	// closed on unsubscribe so that the forwarding goroutine never stays blocked on an abandoned channel
+	done	chan struct{}
+}
+
+func (rs *redisSubscription) close() error {
+	close(rs.done)
+	return rs.pubSub.Close()
+}
+
+func NewRedisBroker(client *redis.Client, bufferSize int64) *RedisBroker {
+	return &RedisBroker{
+		client:        client,
+		bufferSize:    bufferSize,
+		subscriptions: make(map[string]*redisSubscription),
+	}
+}
+
+func (rb *RedisBroker) Publish(ctx context.Context, channel string, data []byte) error {
+	return rb.client.Publish(ctx, channel, data).Err()
+}
+
+func (rb *RedisBroker) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+
+	rb.Lock()
+	defer rb.Unlock()
+
+	if old, ok := rb.subscriptions[channel]; ok {
+		if err := old.close(); err != nil {
+			AppLogger.Errorf("[RedisBroker.Subscribe] channel: %s error occurred while closing previous subscription: %v", channel, err)
+		}
+	}
+
+	sub := &redisSubscription{
+		pubSub: rb.client.Subscribe(ctx, channel),
+		done:   make(chan struct{}),
+	}
+	rb.subscriptions[channel] = sub
+
+	out := make(chan []byte, rb.bufferSize)
+	incoming := sub.pubSub.Channel()
+
+	go func() {
+		defer close(out)
+		for redisMsg := range incoming {
+			select {
+			case out <- []byte(redisMsg.Payload):
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (rb *RedisBroker) Unsubscribe(ctx context.Context, channel string) error {
+
+	rb.Lock()
+	sub, ok := rb.subscriptions[channel]
+	delete(rb.subscriptions, channel)
+	rb.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return sub.close()
+}
+
+func (rb *RedisBroker) Close() error {
+
+	rb.Lock()
+	for channel, sub := range rb.subscriptions {
+		if err := sub.close(); err != nil {
+			AppLogger.Errorf("[RedisBroker.Close] channel: %s error occurred while closing subscription: %v", channel, err)
+		}
+		delete(rb.subscriptions, channel)
+	}
+	rb.Unlock()
+
+	return rb.client.Close()
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+const (
+
+	// field of the stream entry holding the published data
+	streamDataField = "data"
+
+	// how long a single XREAD call blocks waiting for new entries
+	streamReadBlock = 5 * time.Second
+
+	// pause before reading again after a failed XREAD
+	streamRetryBackoff = time.Second
+
+)
+
+// Broker backed by redis streams. Every node reads the stream independently from the position at which it
+// subscribed and keeps track of the last entry it delivered, so a node whose connection to redis drops
+// resumes where it stopped instead of losing what was published meanwhile.
+type RedisStreamBroker struct {
+	sync.Mutex
+
+	client			*redis.Client
+
+	// buffer size of every subscription channel
+	bufferSize		int64
+
+	// approximate number of entries retained per stream, 0 keeps everything
+	maxLength		int64
+
+	subscriptions	map[string]context.CancelFunc
+}
+
+func NewRedisStreamBroker(client *redis.Client, bufferSize int64, maxLength int64) *RedisStreamBroker {
+	return &RedisStreamBroker{
+		client:        client,
+		bufferSize:    bufferSize,
+		maxLength:     maxLength,
+		subscriptions: make(map[string]context.CancelFunc),
+	}
+}
+
+func (rsb *RedisStreamBroker) Publish(ctx context.Context, channel string, data []byte) error {
+	return rsb.client.XAdd(ctx, &redis.XAddArgs{
+		Stream:       channel,
+		MaxLenApprox: rsb.maxLength,
+		Values:       map[string]interface{}{streamDataField: data},
+	}).Err()
+}
+
+func (rsb *RedisStreamBroker) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+
+	rsb.Lock()
+	defer rsb.Unlock()
+
+	if cancel, ok := rsb.subscriptions[channel]; ok {
+		cancel()
+	}
+
+	readCtx, cancel := context.WithCancel(context.Background())
+	rsb.subscriptions[channel] = cancel
+
+	out := make(chan []byte, rsb.bufferSize)
+	go rsb.readStream(readCtx, channel, out)
+
+	return out, nil
+}
+
+// reads the stream entries added after the subscription and forwards them until the context is cancelled
+func (rsb *RedisStreamBroker) readStream(ctx context.Context, channel string, out chan<- []byte) {
+
+	defer close(out)
+
+	// only the entries added from now on are of interest
+	lastId := rsb.lastEntryId(ctx, channel)
+
+	for {
+		streams, err := rsb.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{channel, lastId},
+			Block:   streamReadBlock,
+		}).Result()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				AppLogger.Errorf("[RedisStreamBroker.readStream] channel: %s error occurred while reading stream: %v", channel, err)
+				time.Sleep(streamRetryBackoff)
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				lastId = entry.ID
+
+				data, ok := entry.Values[streamDataField].(string)
+				if !ok {
+					AppLogger.Errorf("[RedisStreamBroker.readStream] channel: %s entry: %s has no data", channel, entry.ID)
+					continue
+				}
+
+				select {
+				case out <- []byte(data):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// resolves the id of the newest entry of the stream so that reading resumes from a fixed position
+// even when the first XREAD fails
+func (rsb *RedisStreamBroker) lastEntryId(ctx context.Context, channel string) string {
+
+	entries, err := rsb.client.XRevRangeN(ctx, channel, "+", "-", 1).Result()
+	if err != nil {
+		AppLogger.Errorf("[RedisStreamBroker.lastEntryId] channel: %s error occurred while reading stream: %v", channel, err)
+		return "$"
+	}
+	if len(entries) == 0 {
+		return "0-0"
+	}
+	return entries[0].ID
+}
+
+func (rsb *RedisStreamBroker) Unsubscribe(ctx context.Context, channel string) error {
+
+	rsb.Lock()
+	if cancel, ok := rsb.subscriptions[channel]; ok {
+		cancel()
+		delete(rsb.subscriptions, channel)
+	}
+	rsb.Unlock()
+
+	return nil
+}
+
+func (rsb *RedisStreamBroker) Close() error {
+
+	rsb.Lock()
+	for channel, cancel := range rsb.subscriptions {
+		cancel()
+		delete(rsb.subscriptions, channel)
+	}
+	rsb.Unlock()
+
+	return rsb.client.Close()
+}
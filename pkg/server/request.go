@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// sends a request to the client and waits for its correlated reply, for at most RequestTimeout
+// when the context has no deadline. A reply of the error event is returned as a HandlerError, and
+// ErrClientClosed is returned when the client disconnects before replying
+func (ss *SocketServer) Request(ctx context.Context, clientId string, event string, payload map[string]interface{}) (*Message, error) {
+
+	client := ss.findClient(clientId)
+	if client == nil {
+		return nil, ErrClientNotFound
+	}
+	return ss.requestClient(ctx, client, event, payload)
+}
+
+func (ss *SocketServer) requestClient(ctx context.Context, client *socketClient, event string, payload map[string]interface{}) (*Message, error) {
+
+	if _, ok := ctx.Deadline(); !ok && ss.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ss.RequestTimeout)
+		defer cancel()
+	}
+
+	id := uuid.New().String()
+	replyChan := client.awaitReply(id)
+	defer client.forgetReply(id)
+
+	if err := ss.pushMessage(client, Message{Event: event, Id: id, Payload: payload}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyChan:
+		if reply.Event == ErrorEvent {
+			code, _ := reply.Payload["code"].(string)
+			message, _ := reply.Payload["message"].(string)
+			return reply, NewHandlerError(code, message)
+		}
+		return reply, nil
+
+	case <-client.done:
+		return nil, ErrClientClosed
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// registers a pending request of the server and returns the channel its reply is delivered on
+func (cl *socketClient) awaitReply(id string) <-chan *Message {
+
+	replyChan := make(chan *Message, 1)
+
+	cl.pendingLock.Lock()
+	cl.pendingRequests[id] = replyChan
+	cl.pendingLock.Unlock()
+
+	return replyChan
+}
+
+func (cl *socketClient) forgetReply(id string) {
+	cl.pendingLock.Lock()
+	delete(cl.pendingRequests, id)
+	cl.pendingLock.Unlock()
+}
+
+// delivers the reply of the client to the pending request it answers, returns false if no such request is pending
+func (cl *socketClient) resolveReply(msg *Message) bool {
+
+	cl.pendingLock.Lock()
+	replyChan, ok := cl.pendingRequests[msg.ReplyTo]
+	delete(cl.pendingRequests, msg.ReplyTo)
+	cl.pendingLock.Unlock()
+
+	if ok {
+		replyChan <- msg
+	}
+	return ok
+}
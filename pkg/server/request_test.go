@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestServerRequest(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		answer func(t *testing.T, conn net.Conn, request *Message)
+		event  string
+		err    error
+	}{
+		{
+			name: "reply",
+			answer: func(t *testing.T, conn net.Conn, request *Message) {
+				reply := fmt.Sprintf(`{"event":"ping","reply_to":%q,"payload":{"answer":"pong"}}`, request.Id)
+				if err := wsutil.WriteClientText(conn, []byte(reply)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: "ping",
+		},
+		{
+			name: "error reply",
+			answer: func(t *testing.T, conn net.Conn, request *Message) {
+				reply := fmt.Sprintf(`{"event":%q,"reply_to":%q,"payload":{"code":"busy","message":"try later"}}`, ErrorEvent, request.Id)
+				if err := wsutil.WriteClientText(conn, []byte(reply)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: ErrorEvent,
+			err:   NewHandlerError("busy", "try later"),
+		},
+		{
+			name:   "client disconnecting",
+			answer: func(t *testing.T, conn net.Conn, request *Message) { conn.Close() },
+			err:    ErrClientClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// the request waits for as long as it takes
+			cfg := DefaultServerConfiguration(":0").SetRequestTimeout(0)
+			cfg.PingInterval = 0
+			ss := NewSocketServer(cfg, NewMemoryBroker(10))
+			connected := make(chan string, 1)
+			ss.OnClientConnected = func(clientId string) { connected <- clientId }
+
+			srv := httptest.NewServer(ss)
+			defer srv.Close()
+			conn := dialTestClient(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+			clientId := <-connected
+
+			type result struct {
+				reply *Message
+				err   error
+			}
+			done := make(chan result, 1)
+			go func() {
+				reply, err := ss.Request(context.Background(), clientId, "ping", map[string]interface{}{"n": 1})
+				done <- result{reply, err}
+			}()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			data, err := wsutil.ReadServerText(conn)
+			if err != nil {
+				t.Fatalf("read request: %v", err)
+			}
+			var request Message
+			if err := json.Unmarshal(data, &request); err != nil {
+				t.Fatal(err)
+			}
+			if request.Event != "ping" || request.Id == "" {
+				t.Fatalf("got request %s with id %q", request.Event, request.Id)
+			}
+			tt.answer(t, conn, &request)
+
+			select {
+			case res := <-done:
+				if fmt.Sprint(res.err) != fmt.Sprint(tt.err) {
+					t.Fatalf("got error %v, want %v", res.err, tt.err)
+				}
+				if tt.event != "" && (res.reply == nil || res.reply.Event != tt.event) {
+					t.Fatalf("got reply %+v, want event %s", res.reply, tt.event)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("request still waiting")
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handles an incoming Message whose event it has been registered for
+type EventHandler func(ctx *EventContext) error
+
+// everything an EventHandler needs to know about the Message it is handling
+type EventContext struct {
+
+	// client which sent the message
+	Client	*socketClient
+
+	// group the client belongs to
+	Group	*group
+
+	// decoded message as received from the client
+	Message	*Message
+
+	server	*SocketServer
+
+	// cancelled once the request times out or its handling is over
+	ctx		context.Context
+	cancel	context.CancelFunc
+
+	// set once the reply to a request has been sent, a request gets a single reply
+	replied	int32
+}
+
+func newEventContext(ss *SocketServer, client *socketClient, msg *Message) *EventContext {
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	if ss.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), ss.RequestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	return &EventContext{
+		Client:  client,
+		Group:   client.group,
+		Message: msg,
+		server:  ss,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// context of the handling, done when the request times out
+func (ctx *EventContext) Context() context.Context {
+	return ctx.ctx
+}
+
+// whether the client expects a correlated reply to the message
+func (ctx *EventContext) IsRequest() bool {
+	return ctx.Message.Id != ""
+}
+
+// event of the message being handled
+func (ctx *EventContext) Event() string {
+	return ctx.Message.Event
+}
+
+// decoded payload of the message being handled
+func (ctx *EventContext) Payload() map[string]interface{} {
+	return ctx.Message.Payload
+}
+
+// sends the payload back to the client under the event of the message being handled,
+// correlated to the message when it is a request
+func (ctx *EventContext) Reply(payload map[string]interface{}) error {
+	return ctx.respond(Message{Event: ctx.Message.Event, Payload: payload})
+}
+
+// sends the payload to the client under the given event
+func (ctx *EventContext) Emit(event string, payload map[string]interface{}) error {
+	return ctx.server.pushMessage(ctx.Client, Message{Event: event, Payload: payload})
+}
+
+// sends a request to the client and waits for its reply until the handling context is done
+func (ctx *EventContext) Request(event string, payload map[string]interface{}) (*Message, error) {
+	return ctx.server.requestClient(ctx.ctx, ctx.Client, event, payload)
+}
+
+// sends the reply of the message, correlating it and making sure a request is only replied to once
+func (ctx *EventContext) respond(msg Message) error {
+
+	if ctx.IsRequest() {
+		if !atomic.CompareAndSwapInt32(&ctx.replied, 0, 1) {
+			return ErrAlreadyReplied
+		}
+		msg.ReplyTo = ctx.Message.Id
+	}
+	return ctx.server.pushMessage(ctx.Client, msg)
+}
+
+// whether the reply to the request has already been sent
+func (ctx *EventContext) hasReplied() bool {
+	return atomic.LoadInt32(&ctx.replied) == 1
+}
+
+// sends the error back to the client as an error frame, errors other than a HandlerError are reported
+// as internal errors without their details
+func (ctx *EventContext) ReplyError(err error) error {
+
+	var handlerErr *HandlerError
+	if !errors.As(err, &handlerErr) {
+		handlerErr = NewHandlerError(ErrCodeInternal, "internal error")
+	}
+
+	return ctx.respond(Message{Event: ErrorEvent, Payload: map[string]interface{}{
+		"event":   ctx.Message.Event,
+		"code":    handlerErr.Code,
+		"message": handlerErr.Message,
+	}})
+}
+
+// broadcasts the payload under the given event to every client of the group, across all the nodes
+func (ctx *EventContext) BroadcastToGroup(event string, payload map[string]interface{}) {
+	ctx.server.BroadcastToGroup(ctx.Group.Id, &Message{Event: event, Payload: payload})
+}
+
+// dispatches incoming messages to the handler registered for their event
+type router struct {
+	sync.RWMutex
+
+	handlers	map[string]EventHandler
+
+	// called for the events no handler has been registered for
+	fallback	EventHandler
+
+	// wrapped around every handler, the fallback included
+	middlewares	[]Middleware
+
+	// handlers still running, waited for on shutdown
+	drainLock	sync.Mutex
+	draining	bool
+	inFlight	sync.WaitGroup
+}
+
+func newRouter() *router {
+	return &router{
+		handlers: make(map[string]EventHandler),
+		fallback: DefaultUnknownEventHandler,
+	}
+}
+
+func (r *router) on(event string, handler EventHandler) {
+	r.Lock()
+	r.handlers[event] = handler
+	r.Unlock()
+}
+
+func (r *router) use(middlewares ...Middleware) {
+	r.Lock()
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.Unlock()
+}
+
+func (r *router) onUnknownEvent(handler EventHandler) {
+	r.Lock()
+	r.fallback = handler
+	r.Unlock()
+}
+
+// finds the handler of the event, falling back to the unknown event handler, wrapped in the middlewares
+func (r *router) handler(event string) EventHandler {
+
+	r.RLock()
+	defer r.RUnlock()
+
+	handler, ok := r.handlers[event]
+	if !ok {
+		handler = r.fallback
+	}
+	return chainMiddlewares(handler, r.middlewares)
+}
+
+// registers a handling about to be dispatched, returns false once the router is draining
+func (r *router) begin() bool {
+
+	r.drainLock.Lock()
+	defer r.drainLock.Unlock()
+
+	if r.draining {
+		return false
+	}
+	r.inFlight.Add(1)
+	return true
+}
+
+// stops accepting new handlings and returns a channel closed once the in-flight ones are over
+func (r *router) drain() <-chan struct{} {
+
+	r.drainLock.Lock()
+	r.draining = true
+	r.drainLock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		r.inFlight.Wait()
+		close(drained)
+	}()
+	return drained
+}
+
+// runs the handler of the message event on the calling goroutine and reports its failure back to the client.
+// A request still unanswered once its context times out gets a timeout error as its reply right away, even
+// though the handler keeps running. Must be preceded by begin
+func (r *router) dispatch(ctx *EventContext) {
+
+	defer r.inFlight.Done()
+	defer ctx.cancel()
+
+	if deadline, ok := ctx.ctx.Deadline(); ok && ctx.IsRequest() {
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			r.timeout(ctx)
+		})
+		defer timer.Stop()
+	}
+
+	handler := r.handler(ctx.Message.Event)
+	err := handler(ctx)
+
+	if ctx.ctx.Err() == context.DeadlineExceeded && !ctx.IsRequest() {
+		AppLogger.Errorf("[dispatch] client: %s event: %s handler timed out", ctx.Client.Id, ctx.Message.Event)
+	}
+
+	if err != nil {
+		AppLogger.Errorf("[dispatch] client: %s event: %s handler failed: %v", ctx.Client.Id, ctx.Message.Event, err)
+		if err := ctx.ReplyError(err); err != nil && err != ErrAlreadyReplied {
+			AppLogger.Errorf("[dispatch] client: %s error occurred while sending error frame: %v", ctx.Client.Id, err)
+		}
+		return
+	}
+
+	// a request always gets a reply so that the client is not left waiting
+	if ctx.IsRequest() && !ctx.hasReplied() {
+		if err := ctx.Reply(nil); err != nil && err != ErrAlreadyReplied {
+			AppLogger.Errorf("[dispatch] client: %s error occurred while sending reply: %v", ctx.Client.Id, err)
+		}
+	}
+}
+
+// releases a handling which began but will not be dispatched
+func (r *router) abandon(ctx *EventContext) {
+	ctx.cancel()
+	r.inFlight.Done()
+}
+
+// replies to the request whose handling timed out with a timeout error
+func (r *router) timeout(ctx *EventContext) {
+
+	AppLogger.Errorf("[timeout] client: %s event: %s handler timed out", ctx.Client.Id, ctx.Message.Event)
+	if err := ctx.ReplyError(NewHandlerError(ErrCodeTimeout, "request timed out")); err != nil && err != ErrAlreadyReplied {
+		AppLogger.Errorf("[timeout] client: %s error occurred while sending error frame: %v", ctx.Client.Id, err)
+	}
+}
+
+func DefaultUnknownEventHandler(ctx *EventContext) error {
+	AppLogger.Infof("[DefaultUnknownEventHandler] client: %s no handler registered for event: %s", ctx.Client.Id, ctx.Message.Event)
+	return nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// runs the tasks submitted through it on the worker pool one at a time and in the order they were submitted,
+// so that the messages of a client are handled in the order they arrived while the messages of different
+// clients are handled in parallel. A single pool task drains the queue while it holds tasks
+type serialQueue struct {
+	pool	*workerPool
+
+	lock	sync.Mutex
+
+	// signalled whenever a task leaves the queue or the queue stops running
+	room	*sync.Cond
+
+	// tasks waiting for the running one, at most limit of them
+	tasks	[]func()
+	limit	int
+
+	// whether a pool task is draining the queue
+	running	bool
+}
+
+func newSerialQueue(pool *workerPool, limit int) *serialQueue {
+	if limit < 0 {
+		limit = 0
+	}
+	q := &serialQueue{pool: pool, limit: limit}
+	q.room = sync.NewCond(&q.lock)
+	return q
+}
+
+// runs the task after the tasks submitted before it. Once limit tasks are waiting, the policy of the pool
+// applies as if the pool itself were full: the submitter waits for room or errPoolFull is returned
+func (q *serialQueue) submit(task func()) error {
+
+	q.lock.Lock()
+	for {
+		if !q.running {
+			q.running = true
+			q.lock.Unlock()
+
+			if err := q.pool.submit(func() { q.drain(task) }); err != nil {
+				q.lock.Lock()
+				q.running = false
+				q.room.Broadcast()
+				q.lock.Unlock()
+				return err
+			}
+			return nil
+		}
+
+		if len(q.tasks) < q.limit {
+			q.tasks = append(q.tasks, task)
+			q.lock.Unlock()
+			atomic.AddUint64(&q.pool.submitted, 1)
+			atomic.AddInt64(&q.pool.pending, 1)
+			return nil
+		}
+
+		if q.pool.policy != POOL_FULL_POLICY_BLOCK {
+			q.lock.Unlock()
+			atomic.AddUint64(&q.pool.submitted, 1)
+			atomic.AddUint64(&q.pool.refused, 1)
+			return errPoolFull
+		}
+		q.room.Wait()
+	}
+}
+
+// runs the task and then the queued ones until the queue is empty
+func (q *serialQueue) drain(task func()) {
+
+	for {
+		task()
+
+		q.lock.Lock()
+		if len(q.tasks) == 0 {
+			q.running = false
+			q.room.Broadcast()
+			q.lock.Unlock()
+			return
+		}
+
+		task = q.tasks[0]
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+		q.room.Broadcast()
+		q.lock.Unlock()
+		atomic.AddInt64(&q.pool.pending, -1)
+	}
+}
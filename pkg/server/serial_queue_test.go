@@ -0,0 +1,156 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSerialQueueRunsTasksInOrder(t *testing.T) {
+
+	pool := newWorkerPool(8, 100, POOL_FULL_POLICY_BLOCK)
+	queues := []*serialQueue{newSerialQueue(pool, 100), newSerialQueue(pool, 100)}
+
+	var lock sync.Mutex
+	order := make([][]int, len(queues))
+	running := make([]int, len(queues))
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		for n, q := range queues {
+			n, i := n, i
+			wg.Add(1)
+			if err := q.submit(func() {
+				defer wg.Done()
+
+				lock.Lock()
+				running[n]++
+				if running[n] > 1 {
+					t.Errorf("queue %d runs %d tasks at once", n, running[n])
+				}
+				lock.Unlock()
+
+				time.Sleep(time.Millisecond / 10)
+
+				lock.Lock()
+				running[n]--
+				order[n] = append(order[n], i)
+				lock.Unlock()
+			}); err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for n := range queues {
+		for i, got := range order[n] {
+			if got != i {
+				t.Fatalf("queue %d ran task %d at position %d: %v", n, got, i, order[n])
+			}
+		}
+	}
+}
+
+func TestSerialQueueFullPolicy(t *testing.T) {
+
+	tests := []struct {
+		policy  PoolFullPolicy
+		refused bool
+	}{
+		{POOL_FULL_POLICY_DROP, true},
+		{POOL_FULL_POLICY_REJECT, true},
+		{POOL_FULL_POLICY_BLOCK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+
+			pool := newWorkerPool(4, 10, tt.policy)
+			q := newSerialQueue(pool, 1)
+
+			release := make(chan struct{})
+			ran := make(chan int, 3)
+			task := func(i int) func() {
+				return func() {
+					if i == 0 {
+						<-release
+					}
+					ran <- i
+				}
+			}
+
+			// the first task runs and blocks, the second one waits, the third one finds the queue full
+			for i := 0; i < 2; i++ {
+				if err := q.submit(task(i)); err != nil {
+					t.Fatalf("task %d: %v", i, err)
+				}
+			}
+
+			third := make(chan error, 1)
+			go func() { third <- q.submit(task(2)) }()
+
+			if tt.refused {
+				if err := <-third; err != errPoolFull {
+					t.Fatalf("got %v, want %v", err, errPoolFull)
+				}
+				if stats := pool.stats(); stats.Refused != 1 || stats.Submitted != 3 {
+					t.Fatalf("got stats %+v", stats)
+				}
+				close(release)
+				for i := 0; i < 2; i++ {
+					if got := <-ran; got != i {
+						t.Fatalf("got task %d, want %d", got, i)
+					}
+				}
+				return
+			}
+
+			select {
+			case err := <-third:
+				t.Fatalf("submission did not wait for room: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+			if err := <-third; err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+			for i := 0; i < 3; i++ {
+				if got := <-ran; got != i {
+					t.Fatalf("got task %d, want %d", got, i)
+				}
+			}
+		})
+	}
+}
+
+func TestSerialQueueDepthReported(t *testing.T) {
+
+	pool := newWorkerPool(4, 10, POOL_FULL_POLICY_BLOCK)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// every queue runs one blocked task while the others wait behind it
+	for n := 0; n < 3; n++ {
+		q := newSerialQueue(pool, 100)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			if err := q.submit(func() {
+				defer wg.Done()
+				<-release
+			}); err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+		}
+	}
+
+	if stats := pool.stats(); stats.ClientQueueDepth != 12 || stats.QueueDepth != 0 {
+		t.Fatalf("got client queue depth %d and queue depth %d, want 12 and 0", stats.ClientQueueDepth, stats.QueueDepth)
+	}
+
+	close(release)
+	wg.Wait()
+	if stats := pool.stats(); stats.ClientQueueDepth != 0 {
+		t.Fatalf("got client queue depth %d once drained", stats.ClientQueueDepth)
+	}
+}
@@ -1,33 +1,82 @@
 package server
 
 import (
-	"github.com/go-redis/redis/v8"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
+	"github.com/gobwas/ws/wsflate"
 	"github.com/google/uuid"
-	"io"
 	"net"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type SocketServer struct {
 	sync.RWMutex
 	*Configuration
 	*ServerCallbacks
+
+	// encoder of the connections which did not negotiate any registered encoding
 	encoder IEncoder
-	pubSubChan	*redis.PubSub
+
+	// encoders the clients can choose from through the websocket subprotocol, by encoding name
+	encoders	map[Encoding]IEncoder
+
+	// unique identifier of this server node among all the nodes sharing the same broker
+	nodeId	string
+	broker	Broker
 	groups  map[string]*group
+
+	// every client connected to this node, by client id, and the authenticated ones by user id then client id
+	clientsLock	sync.RWMutex
+	clients		map[string]*socketClient
+	users		map[string]map[string]*socketClient
+
+	// listener accepting the connections, closed on shutdown
+	listener	net.Listener
+	shuttingDown	bool
+
+	// closed on shutdown to stop pinging and reaping the clients
+	stopHeartbeat	chan struct{}
+
+	// dispatches the incoming messages to the handlers registered with On
+	router	*router
+
+	// bounded pool of goroutines the incoming messages are handled on
+	pool	*workerPool
+
+	// watches the connections for readability when NetpollEnabled, nil when every connection is read
+	// by a goroutine of its own
+	netpoll	*netpoll
 }
 
-// starts with a basic configuration setting
+// starts with a basic configuration setting, using the broker selected in the configuration
 func DefaultSocketServer(config *Configuration) *SocketServer {
+	return NewSocketServer(config, NewBroker(config))
+}
+
+// starts with a basic configuration setting, carrying group broadcasts over the given broker
+func NewSocketServer(config *Configuration, broker Broker) *SocketServer {
 
 	InitLogger(config.LogLevel, config.LoggerReportCaller)
 
 	obj := &SocketServer{
 		Configuration:   config,
+		nodeId: uuid.New().String(),
+		broker: broker,
 		groups: make(map[string]*group),
+		clients: make(map[string]*socketClient),
+		users: make(map[string]map[string]*socketClient),
+		stopHeartbeat: make(chan struct{}),
+		encoders: map[Encoding]IEncoder{
+			ENCODING_TYPE_JSON:     &JsonEncoder{},
+			ENCODING_TYPE_MSG_PACK: &MsgPackEncoder{},
+			ENCODING_TYPE_PROTOBUF: &ProtobufEncoder{},
+		},
+		router: newRouter(),
+		pool: newWorkerPool(config.MaxThreadPoolConcurrency, config.RequestsBufferSize, config.PoolFullPolicy),
 		//group:      	newGroup(config.BroadcastMessagesLimit),
 		ServerCallbacks: NewServerCallbacks(nil,
 			nil,
@@ -38,19 +87,30 @@ func DefaultSocketServer(config *Configuration) *SocketServer {
 			nil),
 	}
 
-	var appEncoder IEncoder
-
-	switch obj.AcceptMessageEncoding {
-	case ENCODING_TYPE_JSON:
-		appEncoder = &JsonEncoder{}
-	case ENCODING_TYPE_MSG_PACK:
-		panic("encoding using msg_pack has not been implemented yet")
+	appEncoder, ok := obj.encoders[obj.AcceptMessageEncoding]
+	if !ok {
+		panic("no encoder registered for the encoding: " + string(obj.AcceptMessageEncoding))
 	}
 	obj.encoder = appEncoder
 
+	if config.NetpollEnabled {
+		// the connections are read on a pool of their own, a read waiting for room in the handling pool
+		// must not hold the worker which would make room in it
+		netpoll, err := newNetpoll(newWorkerPool(config.MaxThreadPoolConcurrency, 0, POOL_FULL_POLICY_BLOCK))
+		if err != nil {
+			AppLogger.Errorln("[NewSocketServer] netpoll unavailable, reading every connection from a goroutine of its own: ", err)
+		} else {
+			obj.netpoll = netpoll
+		}
+	}
+
 	// seeding every server instance with a default group
 	obj.AddGroup("default", config.BroadcastMessagesLimit)
 
+	obj.subscribeUsers()
+
+	obj.startHeartbeat()
+
 	return obj
 }
 
@@ -59,8 +119,10 @@ func (ss *SocketServer) AddGroup(groupId string, groupBroadcastChannelLimit int6
 
 	ss.Lock()
 	if _, ok := ss.groups[groupId]; !ok {
-		group := newGroup(groupId, groupBroadcastChannelLimit)
-		group.createPubSubConnection(groupId)
+		group := newGroup(groupId, groupBroadcastChannelLimit, ss.nodeId, ss.broker)
+		group.subscribe()
+		group.broadcastReceiver()
+		group.subscriptionReceiver()
 		ss.groups[groupId] = group
 	}
 	ss.Unlock()
@@ -75,8 +137,9 @@ func (ss *SocketServer) AddClient(groupId string, clientObj *socketClient) {
 }
 
 // does some initial checks to ensure the presence of all handler functions in place.
-// increases the ulimit and starts the server loop
-func (ss *SocketServer) StartListening() {
+// increases the ulimit and starts the server loop. Returns nil once the server is shut down, or the error
+// which stopped it from listening or accepting connections
+func (ss *SocketServer) StartListening() error {
 
 	// TODO increase ulimit
 	var rLimit syscall.Rlimit
@@ -90,15 +153,24 @@ func (ss *SocketServer) StartListening() {
 	}
 
 	// finally starting the server loop
-	ss.startServerLoop()
+	return ss.startServerLoop()
 }
 
-func (ss *SocketServer) startServerLoop() {
+func (ss *SocketServer) startServerLoop() error {
 
 	listener, err := ss.setupTCPConnection()
 	if err != nil {
-		return // stop the loop
+		return err // stop the loop
+	}
+
+	ss.Lock()
+	if ss.shuttingDown {
+		ss.Unlock()
+		listener.Close()
+		return nil
 	}
+	ss.listener = listener
+	ss.Unlock()
 
 	// starting the blocking loop here
 	for {
@@ -106,28 +178,84 @@ func (ss *SocketServer) startServerLoop() {
 		// accepting a new connection
 		conn, err := listener.Accept()
 		if err != nil {
+			if ss.isShuttingDown() {
+				AppLogger.Infoln("[startServerLoop] stopped accepting connections")
+				return nil
+			}
 			AppLogger.Errorln("[startServerLoop] error occurred while listening to next connection: ", err)
-			return
+			return err
 		}
 
 		AppLogger.Infoln("incoming connection from: ", conn.RemoteAddr())
 
-		// upgrade the tcp connection to websocket protocol
-		_, err = ss.tcpConnectionUpgrader().Upgrade(conn)
-		if err != nil {
-			AppLogger.Errorln("[startServerLoop] error occurred while upgrading TCP connection to websocket: ", err)
-			continue
+		// a client slow to handshake must not hold the next connections back
+		go ss.upgradeConnection(conn)
+	}
+}
+
+// completes the TLS handshake of an accepted connection and upgrades it to websocket protocol within the
+// HandshakeTimeout, then starts handling its messages
+func (ss *SocketServer) upgradeConnection(conn net.Conn) {
+
+	if ss.HandshakeTimeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(ss.HandshakeTimeout)); err != nil {
+			AppLogger.Errorln("[upgradeConnection] error occurred while setting handshake deadline: ", err)
+			conn.Close()
+			return
 		}
+	}
+
+	var certificate *ClientCertificate
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			AppLogger.Errorln("[upgradeConnection] error occurred during TLS handshake: ", err)
+			conn.Close()
+			return
+		}
+		state := tlsConn.ConnectionState()
+		certificate = verifiedClientCertificate(&state)
+	}
+
+	// upgrade the tcp connection to websocket protocol
+	negotiation := &compressionNegotiation{cfg: ss.Configuration}
+	capture := &tokenCapture{cfg: ss.Configuration}
+	handshake, err := ss.tcpConnectionUpgrader(negotiation, capture).Upgrade(conn)
+	if err != nil {
+		AppLogger.Errorln("[upgradeConnection] error occurred while upgrading TCP connection to websocket: ", err)
+		conn.Close()
+		return
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		AppLogger.Errorln("[upgradeConnection] error occurred while clearing handshake deadline: ", err)
+		conn.Close()
+		return
+	}
 
-		ss.handleMessages(conn)
+	if ss.closeIfShuttingDown(conn) {
+		return
+	}
+
+	ss.handleMessages(conn, &upgradeInfo{
+		encoder:     ss.negotiatedEncoder(handshake.Protocol),
+		compression: negotiation.accepted,
+		token:       capture.token(),
+		certificate: certificate,
+	})
+}
 
-		//id := uuid.New().String()
-		//incomingClient := newSocketClient(id, &conn)
-		//
-		//if ss.OnClientConnected != nil {
-		//	ss.OnClientConnected(id)
-		//}
+// closes the upgraded connection with a going away status when the server started shutting down during
+// the handshake, possibly after collecting the clients to close. Returns whether the connection was closed
+func (ss *SocketServer) closeIfShuttingDown(conn net.Conn) bool {
+
+	if !ss.isShuttingDown() {
+		return false
 	}
+
+	closeBody := ws.NewCloseFrameBody(ws.StatusGoingAway, "server shutting down")
+	ws.WriteFrame(conn, ws.NewCloseFrame(closeBody))
+	conn.Close()
+	return true
 }
 
 // setups the tcp connection for the given address
@@ -139,12 +267,25 @@ func (ss *SocketServer) setupTCPConnection() (net.Listener, error) {
 		return nil, err
 	}
 
+	if ss.TLSEnabled() {
+		tlsConfig, err := newServerTLSConfig(ss.Configuration)
+		if err != nil {
+			AppLogger.Errorln("[setupTCPConnection] error occurred while setting up TLS: ", err)
+			listener.Close()
+			return nil, err
+		}
+
+		listener = tls.NewListener(listener, tlsConfig)
+		AppLogger.Infoln("[setupTCPConnection] serving TLS connections")
+	}
+
 	AppLogger.Infoln("[setupTCPConnection] server listening for TCP connections on the address: ", ss.HostAddr)
 	return listener, nil
 }
 
-// upgrades a tcp connection to websocket protocol
-func (ss *SocketServer) tcpConnectionUpgrader() *ws.Upgrader {
+// upgrades a tcp connection to websocket protocol, the negotiation records the compression accepted for it
+// and the capture the token the client presented
+func (ss *SocketServer) tcpConnectionUpgrader(negotiation *compressionNegotiation, capture *tokenCapture) *ws.Upgrader {
 
 	// initializing these functions if they are not initialized by the user
 	if ss.OnHostConnectHandler == nil {
@@ -163,116 +304,374 @@ func (ss *SocketServer) tcpConnectionUpgrader() *ws.Upgrader {
 		}
 	}
 
-	return &ws.Upgrader{
+	upgrader := &ws.Upgrader{
 		// Read and Write Buffer Sizes are set to default
 		ReadBufferSize:  0,
 		WriteBufferSize: 0,
+		// the first encoding offered by the client which has an encoder registered is selected
+		Protocol: func(protocol []byte) bool {
+			_, ok := ss.getEncoder(Encoding(protocol))
+			return ok
+		},
 		Header:          nil,
-		OnRequest:       nil,
+		OnRequest:       capture.onRequest,
 		OnHost: 		 ss.OnHostConnectHandler,
-		OnHeader: 		 ss.OnHeaderHandler,
+		OnHeader: func(key, value []byte) error {
+			capture.onHeader(key, value)
+			return ss.OnHeaderHandler(key, value)
+		},
 		OnBeforeUpgrade: ss.OnBeforeUpgrade,
 	}
+	if ss.CompressionEnabled {
+		upgrader.Negotiate = negotiation.negotiate
+	}
+	return upgrader
+}
+
+// what has been learnt about a connection while upgrading it
+type upgradeInfo struct {
+
+	// encoder negotiated for the connection
+	encoder		IEncoder
+
+	// verified TLS client certificate, nil without mutual TLS
+	certificate	*ClientCertificate
+
+	// parameters of the permessage-deflate extension, nil when it was not negotiated
+	compression	*wsflate.Parameters
+
+	// token presented in the upgrade request, empty when none was
+	token		string
 }
 
-func (ss *SocketServer) handleMessages(conn net.Conn) {
+func (ss *SocketServer) handleMessages(conn net.Conn, info *upgradeInfo) {
+
+	client := newSocketClient(uuid.New().String(), &conn, info.encoder)
+	client.certificate = info.certificate
+	client.token = info.token
+	if info.compression != nil {
+		client.compression = newCompression(*info.compression, ss.Configuration)
+	}
+	client.reader = newMessageReader(conn, client, ss.Configuration)
+	client.writeTimeout = ss.WriteTimeout
+	client.outbound = newOutboundQueue(ss.OutboundQueueSize, ss.SlowConsumerPolicy)
+	client.inbound = newSerialQueue(ss.pool, ss.ClientRequestsBufferSize)
+	client.outbound.onSlowConsumer = func(policy SlowConsumerPolicy) {
+		ss.slowConsumer(client, policy)
+	}
+	client.outbound.onWriteError = func(err error) {
+		AppLogger.Infof("[handleMessages] client: %s error occurred while writing, disconnecting: %v", client.Id, err)
+		ss.reapClient(client, ws.StatusGoingAway, "write failed", err)
+	}
+
+	// registered before the client can be closed by anyone, so that its connection is always unwatched
+	if ss.netpoll != nil {
+		client.polled = ss.netpoll.register(conn, func() bool {
+			return ss.readFrame(client)
+		})
+	}
+
+	ss.trackClient(client)
+	ss.OnClientConnected(client.Id)
+
+	if info.certificate != nil {
+		client.SetMetadata(info.certificate.metadata())
+	}
+
+	ss.startAuthDeadline(client)
+
+	// a client with a verified certificate is authenticated by it and skips the authentication message
+	if info.certificate != nil && ss.TLSClientCertAuth {
+		ss.authenticateCertificate(client)
+	}
+
+	if client.polled != nil {
+		err := client.polled.arm()
+		if err == nil {
+			return
+		}
+		AppLogger.Errorf("[handleMessages] client: %s error occurred while watching connection, reading it from a goroutine: %v", client.Id, err)
+	}
+
 	go func() {
+		for ss.readFrame(client) {
+		}
+	}()
+}
 
-		isClientAuthenticated := false
+// reads the next frame of the client and handles the message it completes, returns false once the
+// connection is over
+func (ss *SocketServer) readFrame(client *socketClient) bool {
 
-		for {
+	payload, complete, err := client.reader.step()
+	if err != nil {
+		ss.closeOnReadError(client, err)
+		return false
+	}
 
-			header, err := ws.ReadHeader(conn)
-			if err != nil {
-				// handle error
-				AppLogger.Errorf("[handleMessages] err occurred while reading header: %v", header)
-			} else {
-				AppLogger.Debugf("[handleMessages] headers: %+v", header)
-			}
+	if complete {
+		client.markActive()
+		ss.handleMessage(client, payload)
+	}
+	return true
+}
 
-			if header.OpCode == ws.OpClose {
-				if err := conn.Close(); err != nil {
-					AppLogger.Errorf("[handleMessages] error occurred while closing connections: %v", err)
-				}
-				return
-			}
+func (ss *SocketServer) handleMessage(client *socketClient, payload []byte) {
+
+	// process requests here
+	message, err := client.encoder.Decode(payload)
+	if err != nil {
+		ss.scheduleInOrder(client, func() {
+			ss.OnMessageReceived(client.Id, Message{}, err)
+		})
+		return
+	}
+
+	switch client.getState() {
+	case clientStateConnected:
+		ss.authenticate(client, message)
+		return
+	case clientStateAuthenticated:
+		if AuthenticateMessage(message) {
+			ss.scheduleInOrder(client, func() {
+				ss.reauthenticate(client, message)
+			})
+			return
+		}
+	default:
+		// nothing is handled while the authentication is decided or once the client is closing
+		AppLogger.Debugf("[handleMessage] client: %s message ignored while: %v", client.Id, client.getState())
+		return
+	}
 
-			payload := make([]byte, header.Length)
-			_, err = io.ReadFull(conn, payload)
-			if err != nil {
-				// handle error
-				AppLogger.Errorf("[handleMessages] error occurred while reading payload: %v", err)
+	// replies to the requests of the server are not events of their own, they are resolved right away
+	if message.ReplyTo != "" && client.resolveReply(message) {
+		ss.scheduleInOrder(client, func() {
+			ss.OnMessageReceived(client.Id, *message, nil)
+		})
+		return
+	}
+
+	// no new handling is started once the server is draining for shutdown
+	if !ss.router.begin() {
+		return
+	}
+
+	// the messages of a client are handled one at a time, in the order they arrived
+	ctx := newEventContext(ss, client, message)
+	err = client.inbound.submit(func() {
+		if handlerErr := ss.authorize(client, AUTHZ_ACTION_PUBLISH, ctx.Group.Id, message.Event); handlerErr != nil {
+			if err := ctx.ReplyError(handlerErr); err != nil {
+				AppLogger.Errorf("[handleMessage] client: %s error occurred while sending error frame: %v", client.Id, err)
 			}
-			if header.Masked {
-				ws.Cipher(payload, header.Mask, 0)
+			ss.router.abandon(ctx)
+			return
+		}
+
+		ss.OnMessageReceived(client.Id, *message, nil)
+		ss.sendAcknowledgement(client, message)
+		ss.router.dispatch(ctx)
+	})
+	if err != nil {
+		AppLogger.Errorf("[handleMessage] client: %s event: %s not handled: %v", client.Id, message.Event, err)
+		if ss.PoolFullPolicy == POOL_FULL_POLICY_REJECT {
+			if err := ctx.ReplyError(NewHandlerError(ErrCodeOverloaded, "server is too busy to handle the event")); err != nil {
+				AppLogger.Errorf("[handleMessage] client: %s error occurred while sending error frame: %v", client.Id, err)
 			}
+		}
+		ss.router.abandon(ctx)
+	}
+}
+
+// reports the client whose outbound queue overflowed, and disconnects it when that is the policy
+func (ss *SocketServer) slowConsumer(client *socketClient, policy SlowConsumerPolicy) {
 
-			// process requests here
-			message, _ := ss.encoder.Decode(payload)
+	AppLogger.Debugf("[slowConsumer] client: %s outbound queue is full, applying policy: %s", client.Id, policy)
 
-			if !isClientAuthenticated {
+	if ss.OnSlowConsumer != nil {
+		ss.scheduleCallback(client, func() {
+			ss.OnSlowConsumer(client.Id, policy)
+		})
+	}
 
-				id, _ := uuid.NewUUID()
-				clientObj := newSocketClient(id.String(), &conn)
+	if policy != SLOW_CONSUMER_POLICY_DROP_OLDEST && policy != SLOW_CONSUMER_POLICY_DROP_NEWEST {
+		ss.reapClient(client, ws.StatusPolicyViolation, "slow consumer", ErrSlowConsumer)
+	}
+}
 
-				isClientAuthenticated, _ = ss.AuthHandler(id.String(), *message)
+// runs the callback on the handling pool, the callback is skipped when the pool is full
+func (ss *SocketServer) scheduleCallback(client *socketClient, callback func()) {
+	if err := ss.pool.submit(callback); err != nil {
+		AppLogger.Errorf("[scheduleCallback] client: %s callback not run: %v", client.Id, err)
+	}
+}
 
-				// subscribing a client to a specific group
-				groupId := message.Payload["group"].(string)
-				if groupId != "" {
-					// attach to default group
-					ss.AddGroup(groupId, 100)
-					ss.AddClient(groupId, clientObj)
-				} else {
-					ss.AddClient("default", clientObj)
-				}
+// runs the callback on the handling pool once the messages the client sent before are handled, the callback
+// is skipped when the pool is full
+func (ss *SocketServer) scheduleInOrder(client *socketClient, callback func()) {
+	if err := client.inbound.submit(callback); err != nil {
+		AppLogger.Errorf("[scheduleInOrder] client: %s callback not run: %v", client.Id, err)
+	}
+}
 
-			} else {
-			}
+// snapshot of the pool the incoming messages are handled on, to monitor how busy the server is
+func (ss *SocketServer) PoolStats() PoolStats {
+	return ss.pool.stats()
+}
 
-			// Reset the Masked flag, server frames must not be masked as
-			// RFC6455 says.
-			header.Masked = false
+// ends the connection of a client whose messages can no longer be read: a close handshake it initiated
+// is a normal closure, a protocol violation is answered with the matching close status
+func (ss *SocketServer) closeOnReadError(client *socketClient, err error) {
 
-			if err := ws.WriteHeader(conn, header); err != nil {
-				// handle error
-				AppLogger.Errorf("[handleMessages] error occurred while writing header: %v", err)
-			}
-			if _, err := conn.Write(payload); err != nil {
-				// handle error
-				AppLogger.Errorf("[handleMessages] error occurred while writing payload: %v", err)
-			}
+	if err == errCloseReceived {
+		ss.removeClient(client, nil)
+		return
+	}
+
+	if code := readErrorStatus(err); code != 0 {
+		AppLogger.Infof("[closeOnReadError] client: %s closing connection with status %d: %v", client.Id, code, err)
+		client.setCloseReason(err)
+		client.closeWithStatus(code, err.Error())
+	} else if _, closedByServer := client.getCloseReason(); closedByServer {
+		AppLogger.Debugf("[closeOnReadError] client: %s connection closed by the server: %v", client.Id, err)
+	} else {
+		AppLogger.Errorf("[closeOnReadError] client: %s error occurred while reading message: %v", client.Id, err)
+	}
+	ss.removeClient(client, err)
+}
+
+// keeps track of a client connected to this node, whether it joined a group or not
+func (ss *SocketServer) trackClient(client *socketClient) {
+	ss.clientsLock.Lock()
+	ss.clients[client.Id] = client
+	ss.clientsLock.Unlock()
+}
+
+// closes the connection of the client, removes it from its group and reports its disconnection
+// along with the reason of it, nil when the connection was closed normally
+func (ss *SocketServer) removeClient(client *socketClient, reason error) {
+
+	ss.clientsLock.Lock()
+	_, tracked := ss.clients[client.Id]
+	delete(ss.clients, client.Id)
+	ss.unindexUser(client)
+	ss.clientsLock.Unlock()
+
+	// the client has already been removed
+	if !tracked {
+		return
+	}
+
+	if closeReason, ok := client.getCloseReason(); ok {
+		reason = closeReason.err
+	}
+
+	onDisconnected := func(clientId string, err error) {
+		if reason != nil {
+			err = reason
 		}
-	}()
+		ss.OnClientDisconnected(clientId, err)
+	}
+
+	if client.group != nil {
+		client.group.removeClient(client.Id, onDisconnected)
+		return
+	}
+
+	err := client.StopClient()
+	if err != nil {
+		AppLogger.Errorf("[removeClient] client: %v: %v", client.Id, err)
+	}
+	onDisconnected(client.Id, err)
+}
+
+// registers the handler called for every incoming message of the given event
+func (ss *SocketServer) On(event string, handler EventHandler) {
+	ss.router.on(event, handler)
+}
+
+// adds middlewares wrapped around every handler, in the order they are given
+func (ss *SocketServer) Use(middlewares ...Middleware) {
+	ss.router.use(middlewares...)
+}
+
+// registers the handler called for the incoming messages of events no handler has been registered for
+func (ss *SocketServer) OnUnknownEvent(handler EventHandler) {
+	ss.router.onUnknownEvent(handler)
 }
 
 // if enabled this function sends an acknowledgment back to the connected client upon receiving data
-func (ss *SocketServer) sendAcknowledgement(conn *net.Conn, msg *Message) {
+func (ss *SocketServer) sendAcknowledgement(client *socketClient, msg *Message) {
 
 	// if acknowledgement is enabled
 	if ss.SendAcknowledgement {
 
-		data := ss.encoder.Encode(*msg)
-		if data == nil {
-			return
+		ack := Message{
+			Event:   AcknowledgementEvent,
+			ReplyTo: msg.Id,
+			Payload: map[string]interface{}{"event": msg.Event},
 		}
 
-		err := wsutil.WriteServerMessage(*conn, ws.OpText, data)
-		if err != nil {
+		if err := ss.pushMessage(client, ack); err != nil {
 			// log the error which triggered closing the connection
 			AppLogger.Errorln("[sendAcknowledgement] error occurred while sending server reply: ", err)
 		}
 	}
 }
 
+// encodes the message with the encoder of the client and pushes it to the client
+func (ss *SocketServer) pushMessage(client *socketClient, msg Message) error {
+	return client.PushMessage(msg)
+}
+
+// finds a client connected to this node
+func (ss *SocketServer) findClient(clientId string) *socketClient {
+
+	ss.clientsLock.RLock()
+	defer ss.clientsLock.RUnlock()
+
+	return ss.clients[clientId]
+}
+
+// broadcasts the message to every group this node knows about
 func (ss *SocketServer) BroadcastAllGroups(msg *Message) {
-	for groupId, _ := range ss.groups {
-		ss.groups[groupId].createBroadcast(msg)
+
+	ss.RLock()
+	groupIds := make([]string, 0, len(ss.groups))
+	for groupId := range ss.groups {
+		groupIds = append(groupIds, groupId)
+	}
+	ss.RUnlock()
+
+	for _, groupId := range groupIds {
+		ss.BroadcastToGroup(groupId, msg)
 	}
 }
 
+// delivers the message to the local clients of the group and publishes it on the broker so that
+// the other nodes deliver it to theirs
 func (ss *SocketServer) BroadcastToGroup(groupId string, msg *Message) {
-	if val, ok := ss.groups[groupId]; ok {
+
+	ss.RLock()
+	val, ok := ss.groups[groupId]
+	ss.RUnlock()
+
+	if ok {
 		val.createBroadcast(msg)
 	}
+
+	if err := ss.publishBroadcast(groupId, msg); err != nil {
+		AppLogger.Errorf("[BroadcastToGroup] error occurred while publishing broadcast to group: %s: %v", groupId, err)
+	}
+}
+
+// publishes the broadcast on the broker channel of the group
+func (ss *SocketServer) publishBroadcast(groupId string, msg *Message) error {
+
+	msg, raw := detachRawPayload(msg)
+	envelope, err := json.Marshal(broadcastEnvelope{Origin: ss.nodeId, Message: msg, Raw: raw})
+	if err != nil {
+		return err
+	}
+	return ss.broker.Publish(context.Background(), groupChannel(groupId), envelope)
 }
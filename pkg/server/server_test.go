@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// writes a self-signed certificate for 127.0.0.1 and its key to the directory
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// starts the accept loop of the server and returns the address it listens on
+func startTestListener(t *testing.T, ss *SocketServer) string {
+
+	go ss.startServerLoop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ss.RLock()
+		listener := ss.listener
+		ss.RUnlock()
+		if listener != nil {
+			return listener.Addr().String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start listening")
+	return ""
+}
+
+func TestSilentConnectionDoesNotBlockAccepting(t *testing.T) {
+
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	cfg := DefaultServerConfiguration("127.0.0.1:0").SetTLSCertificate(certFile, keyFile).SetHandshakeTimeout(time.Second)
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+	defer ss.Shutdown(context.Background())
+
+	addr := startTestListener(t, ss)
+
+	// connects and never starts the TLS handshake
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	dialer := ws.Dialer{
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Timeout:   500 * time.Millisecond,
+	}
+	conn, _, _, err := dialer.Dial(context.Background(), "wss://"+addr)
+	if err != nil {
+		t.Fatalf("connection behind a silent one not upgraded: %v", err)
+	}
+	conn.Close()
+
+	// the silent connection is closed once the handshake timeout elapses
+	silent.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := silent.Read(make([]byte, 1)); err == nil {
+		t.Fatal("silent connection got data")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("silent connection still open after the handshake timeout")
+	}
+}
+
+// connects a websocket client to the server and authenticates it
+func dialTestClient(t *testing.T, url string) net.Conn {
+
+	conn, _, _, err := ws.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"authenticate","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestClientMessagesHandledInOrder(t *testing.T) {
+
+	cfg := DefaultServerConfiguration(":0").SetSendAcknowledgment(true)
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+
+	var lock sync.Mutex
+	var handled []int
+	ss.On("step", func(ctx *EventContext) error {
+		index, _ := ctx.Payload()["index"].(float64)
+
+		// a handler slower for the first messages would let the later ones overtake them if run in parallel
+		time.Sleep(time.Duration(10-int(index)%10) * time.Millisecond / 5)
+
+		lock.Lock()
+		handled = append(handled, int(index))
+		lock.Unlock()
+		return ctx.Reply(map[string]interface{}{"index": index})
+	})
+
+	srv := httptest.NewServer(ss)
+	defer srv.Close()
+	conn := dialTestClient(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+
+	const count = 30
+	for i := 0; i < count; i++ {
+		message := fmt.Sprintf(`{"event":"step","id":"%d","payload":{"index":%d}}`, i, i)
+		if err := wsutil.WriteClientText(conn, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every message is acknowledged and then replied to before the next one
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < count; i++ {
+		for _, event := range []string{AcknowledgementEvent, "step"} {
+			data, err := wsutil.ReadServerText(conn)
+			if err != nil {
+				t.Fatalf("message %d: %v", i, err)
+			}
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Event != event || msg.ReplyTo != fmt.Sprint(i) {
+				t.Fatalf("got %s replying to %s, want %s replying to %d", msg.Event, msg.ReplyTo, event, i)
+			}
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for i, index := range handled {
+		if index != i {
+			t.Fatalf("handled in order %v", handled)
+		}
+	}
+}
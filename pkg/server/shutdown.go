@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"github.com/gobwas/ws"
+	"sync"
+)
+
+// gracefully shuts the server down: it stops accepting connections and new events, waits for the in-flight
+// handlers, sends a going away close frame to every client, unsubscribes the groups and closes the broker.
+// When the context expires before the handlers are over, the connections are closed anyway and the
+// context error is returned
+func (ss *SocketServer) Shutdown(ctx context.Context) error {
+
+	ss.Lock()
+	if ss.shuttingDown {
+		ss.Unlock()
+		return nil
+	}
+	ss.shuttingDown = true
+	listener := ss.listener
+	ss.Unlock()
+
+	close(ss.stopHeartbeat)
+
+	if listener != nil {
+		if err := listener.Close(); err != nil {
+			AppLogger.Errorf("[Shutdown] error occurred while closing listener: %v", err)
+		}
+	}
+
+	var err error
+	select {
+	case <-ss.router.drain():
+		AppLogger.Infoln("[Shutdown] in-flight handlers are over")
+	case <-ctx.Done():
+		err = ctx.Err()
+		AppLogger.Errorf("[Shutdown] gave up waiting for in-flight handlers: %v", err)
+	}
+
+	ss.clientsLock.RLock()
+	clients := make([]*socketClient, 0, len(ss.clients))
+	for _, client := range ss.clients {
+		clients = append(clients, client)
+	}
+	ss.clientsLock.RUnlock()
+
+	// closed in parallel, every client being given up to its write timeout to take the messages queued for it
+	var closing sync.WaitGroup
+	for _, client := range clients {
+		closing.Add(1)
+		go func(client *socketClient) {
+			defer closing.Done()
+
+			// the reader running into the closed connection must not report it as a read error
+			client.setCloseReason(nil)
+			if closeErr := client.closeWithStatus(ws.StatusGoingAway, "server shutting down"); closeErr != nil {
+				AppLogger.Debugf("[Shutdown] client: %s error occurred while closing connection: %v", client.Id, closeErr)
+			}
+			ss.removeClient(client, nil)
+		}(client)
+	}
+	closing.Wait()
+
+	if ss.netpoll != nil {
+		if closeErr := ss.netpoll.close(); closeErr != nil {
+			AppLogger.Errorf("[Shutdown] error occurred while closing netpoll: %v", closeErr)
+		}
+	}
+
+	ss.Lock()
+	groups := ss.groups
+	ss.groups = make(map[string]*group)
+	ss.Unlock()
+
+	for _, g := range groups {
+		g.shutdown(ctx)
+	}
+
+	if unsubscribeErr := ss.broker.Unsubscribe(ctx, usersChannel); unsubscribeErr != nil {
+		AppLogger.Errorf("[Shutdown] error occurred while unsubscribing from user messages: %v", unsubscribeErr)
+	}
+
+	if closeErr := ss.broker.Close(); closeErr != nil {
+		AppLogger.Errorf("[Shutdown] error occurred while closing broker: %v", closeErr)
+	}
+
+	return err
+}
+
+func (ss *SocketServer) isShuttingDown() bool {
+	ss.RLock()
+	defer ss.RUnlock()
+
+	return ss.shuttingDown
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestShutdownWaitsForHandlers(t *testing.T) {
+
+	cfg := DefaultServerConfiguration(":0").SetSendAcknowledgment(false)
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, NewMemoryBroker(10))
+
+	started := make(chan struct{})
+	ss.On("slow", func(ctx *EventContext) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return ctx.Reply(map[string]interface{}{"done": true})
+	})
+	disconnected := make(chan error, 1)
+	ss.OnClientDisconnected = func(clientId string, err error) { disconnected <- err }
+
+	srv := httptest.NewServer(ss)
+	defer srv.Close()
+	conn := dialTestClient(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"slow","id":"1","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- ss.Shutdown(ctx)
+	}()
+
+	// the reply of the handler in flight comes before the going away close frame
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := wsutil.ReadServerText(conn)
+	if err != nil {
+		t.Fatalf("reply not received: %v", err)
+	}
+	var reply Message
+	if err := json.Unmarshal(data, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Event != "slow" || reply.ReplyTo != "1" || reply.Payload["done"] != true {
+		t.Fatalf("got %s replying to %s: %v", reply.Event, reply.ReplyTo, reply.Payload)
+	}
+
+	_, err = wsutil.ReadServerText(conn)
+	var closed wsutil.ClosedError
+	if !errors.As(err, &closed) || closed.Code != ws.StatusGoingAway {
+		t.Fatalf("got %v, want a close frame with status %d", err, ws.StatusGoingAway)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	select {
+	case err := <-disconnected:
+		if err != nil {
+			t.Fatalf("disconnected with %v, want a normal closure", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnection not reported")
+	}
+}
+
+func TestConnectionUpgradedDuringShutdownClosed(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		listen func(t *testing.T, ss *SocketServer) string
+	}{
+		{
+			name: "mounted on an http server",
+			listen: func(t *testing.T, ss *SocketServer) string {
+				srv := httptest.NewServer(ss)
+				t.Cleanup(srv.Close)
+				return "ws" + strings.TrimPrefix(srv.URL, "http")
+			},
+		},
+		{
+			name: "own listener",
+			listen: func(t *testing.T, ss *SocketServer) string {
+				return "ws://" + startTestListener(t, ss)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cfg := DefaultServerConfiguration("127.0.0.1:0")
+			cfg.PingInterval = 0
+			ss := NewSocketServer(cfg, NewMemoryBroker(10))
+
+			// the server starts shutting down while the connection is being upgraded
+			ss.OnBeforeUpgrade = func() (ws.HandshakeHeader, error) {
+				go ss.Shutdown(context.Background())
+				for !ss.isShuttingDown() {
+					time.Sleep(time.Millisecond)
+				}
+				return nil, nil
+			}
+			url := tt.listen(t, ss)
+
+			conn, buffered, _, err := ws.Dial(context.Background(), url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// the close frame may have been read along with the handshake response
+			var reader io.Reader = conn
+			if buffered != nil {
+				reader = io.MultiReader(buffered, conn)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err = wsutil.ReadServerText(struct {
+				io.Reader
+				io.Writer
+			}{reader, conn})
+			var closed wsutil.ClosedError
+			if !errors.As(err, &closed) || closed.Code != ws.StatusGoingAway {
+				t.Fatalf("got %v, want a close frame with status %d", err, ws.StatusGoingAway)
+			}
+		})
+	}
+}
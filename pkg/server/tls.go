@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// serves the certificate loaded from a pair of files and loads it again once the files change on disk,
+// so that a rotated certificate is picked up by the next handshakes without restarting the server
+type certificateReloader struct {
+	files	*fileReloader
+}
+
+func newCertificateReloader(certFile, keyFile string, checkInterval time.Duration) (*certificateReloader, error) {
+
+	load := func() (interface{}, error) {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &certificate, nil
+	}
+
+	files, err := newFileReloader("certificate", checkInterval, load, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &certificateReloader{files: files}, nil
+}
+
+// tls.Config.GetCertificate implementation, a certificate which fails to load keeps the previous one served
+func (cr *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.files.current().(*tls.Certificate), nil
+}
+
+// builds the TLS configuration of the listener out of the Configuration
+func newServerTLSConfig(cfg *Configuration) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSConfig != nil {
+		tlsConfig = cfg.TLSConfig.Clone()
+	}
+
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, errors.New("both the TLS certificate and key files are required")
+		}
+
+		reloader, err := newCertificateReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	}
+
+	if cfg.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in the TLS client CA file")
+		}
+		tlsConfig.ClientCAs = clientCAs
+	}
+
+	if cfg.TLSClientAuth != tls.NoClientCert {
+		tlsConfig.ClientAuth = cfg.TLSClientAuth
+	}
+
+	return tlsConfig, nil
+}
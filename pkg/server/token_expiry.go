@@ -0,0 +1,82 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// remembers when the identity of the client expires, replacing the previous expiry. The client is sent the
+// TokenExpiringEvent TokenExpiryWarning ahead of it, and disconnected once it lapses unless it authenticated
+// again meanwhile. A zero expiry never lapses
+func (ss *SocketServer) trackExpiry(client *socketClient, expiresAt time.Time) {
+
+	client.expiryLock.Lock()
+	defer client.expiryLock.Unlock()
+
+	if client.expiryTimer != nil {
+		client.expiryTimer.Stop()
+		client.expiryTimer = nil
+	}
+
+	client.expiresAt = expiresAt
+	if expiresAt.IsZero() {
+		return
+	}
+	ss.armExpiry(client, expiresAt, ss.TokenExpiryWarning > 0)
+}
+
+// starts the timer of the next step of the expiry, the warning or the disconnection. Must hold the expiry lock
+func (ss *SocketServer) armExpiry(client *socketClient, expiresAt time.Time, warn bool) {
+
+	at := expiresAt
+	if warn {
+		at = expiresAt.Add(-ss.TokenExpiryWarning)
+	}
+
+	client.expiryTimer = time.AfterFunc(time.Until(at), func() {
+		ss.expire(client, expiresAt, warn)
+	})
+}
+
+func (ss *SocketServer) expire(client *socketClient, expiresAt time.Time, warn bool) {
+
+	client.expiryLock.Lock()
+
+	// the client authenticated again or is closing meanwhile
+	if !client.expiresAt.Equal(expiresAt) || client.getState() == clientStateClosing {
+		client.expiryLock.Unlock()
+		return
+	}
+
+	if warn {
+		ss.armExpiry(client, expiresAt, false)
+		client.expiryLock.Unlock()
+
+		err := ss.pushMessage(client, Message{Event: TokenExpiringEvent, Payload: map[string]interface{}{
+			"expires_at": expiresAt.Unix(),
+		}})
+		if err != nil {
+			AppLogger.Errorf("[expire] client: %s error occurred while sending expiry warning: %v", client.Id, err)
+		}
+		return
+	}
+
+	client.expiryTimer = nil
+	client.expiryLock.Unlock()
+
+	AppLogger.Infof("[expire] client: %s authentication expired, disconnecting", client.Id)
+	ss.reapClient(client, ws.StatusPolicyViolation, "authentication expired", ErrAuthenticationExpired)
+}
+
+// stops tracking the expiry of a client being closed
+func (cl *socketClient) stopExpiry() {
+
+	cl.expiryLock.Lock()
+	defer cl.expiryLock.Unlock()
+
+	if cl.expiryTimer != nil {
+		cl.expiryTimer.Stop()
+		cl.expiryTimer = nil
+	}
+}
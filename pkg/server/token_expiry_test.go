@@ -0,0 +1,121 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// authenticates clients as the user of the payload for the milliseconds of its ttl
+func expiringAuthHandler(req *AuthRequest) (bool, string) {
+	user, _ := req.Message.Payload["user"].(string)
+	ttl, _ := req.Message.Payload["ttl"].(float64)
+	if user == "" {
+		return false, "no user"
+	}
+	req.UserId = user
+	req.Metadata = map[string]interface{}{"ttl": ttl}
+	req.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	return true, "authenticated as " + user
+}
+
+func TestTokenExpiry(t *testing.T) {
+
+	ss, url, disconnected := newAuthTestServer(t, DefaultServerConfiguration(":0").SetTokenExpiryWarning(300*time.Millisecond))
+	ss.AuthHandler = expiringAuthHandler
+
+	conn := dialUnauthenticated(t, url)
+	if err := wsutil.WriteClientText(conn, []byte(`{"event":"authenticate","payload":{"user":"alice","ttl":500}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// warned ahead of the expiry, then disconnected once it lapses
+	start := time.Now()
+	messages, closed := readUntilClosed(t, conn)
+	if len(messages) != 1 || messages[0].Event != TokenExpiringEvent || messages[0].Payload["expires_at"] == nil {
+		t.Fatalf("got messages %+v, want a single %s", messages, TokenExpiringEvent)
+	}
+	if closed.Code != ws.StatusPolicyViolation || closed.Reason != "authentication expired" {
+		t.Fatalf("closed with %d %q", closed.Code, closed.Reason)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("disconnected after %v, before the expiry", elapsed)
+	}
+
+	select {
+	case err := <-disconnected:
+		if err != ErrAuthenticationExpired {
+			t.Fatalf("disconnected with %v, want %v", err, ErrAuthenticationExpired)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnection not reported")
+	}
+}
+
+func TestReauthentication(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		again string
+		code  string
+		kept  bool
+	}{
+		{"refreshed", `{"event":"authenticate","id":"2","payload":{"user":"alice","ttl":60000}}`, "", true},
+		{"rejected", `{"event":"authenticate","id":"2","payload":{}}`, ErrCodeUnauthorized, false},
+		{"as another user", `{"event":"authenticate","id":"2","payload":{"user":"bob","ttl":60000}}`, ErrCodeUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ss, url, disconnected := newAuthTestServer(t, DefaultServerConfiguration(":0").SetTokenExpiryWarning(0))
+			ss.AuthHandler = expiringAuthHandler
+
+			conn := dialUnauthenticated(t, url)
+			if err := wsutil.WriteClientText(conn, []byte(`{"event":"authenticate","payload":{"user":"alice","ttl":700}}`)); err != nil {
+				t.Fatal(err)
+			}
+			if err := wsutil.WriteClientText(conn, []byte(tt.again)); err != nil {
+				t.Fatal(err)
+			}
+
+			reply := readMessage(t, conn)
+			if reply.ReplyTo != "2" {
+				t.Fatalf("got %s replying to %q", reply.Event, reply.ReplyTo)
+			}
+			if tt.code != "" {
+				if reply.Event != ErrorEvent || reply.Payload["code"] != tt.code {
+					t.Fatalf("got %s %v, want a %s error", reply.Event, reply.Payload, tt.code)
+				}
+			} else if reply.Event != AuthenticationEvent || reply.Payload["expires_at"] == nil {
+				t.Fatalf("got %s %v, want the new expiry", reply.Event, reply.Payload)
+			}
+
+			// a refused client keeps its previous authentication until it lapses
+			select {
+			case err := <-disconnected:
+				if tt.kept {
+					t.Fatalf("refreshed client disconnected: %v", err)
+				}
+				if err != ErrAuthenticationExpired {
+					t.Fatalf("disconnected with %v, want %v", err, ErrAuthenticationExpired)
+				}
+			case <-time.After(1500 * time.Millisecond):
+				if !tt.kept {
+					t.Fatal("client kept past the expiry of its previous authentication")
+				}
+			}
+
+			if tt.kept {
+				if err := wsutil.WriteClientText(conn, []byte(`{"event":"echo","id":"3","payload":{}}`)); err != nil {
+					t.Fatal(err)
+				}
+				if reply := readMessage(t, conn); reply.Event != "echo" || reply.ReplyTo != "3" {
+					t.Fatalf("got %s replying to %s", reply.Event, reply.ReplyTo)
+				}
+			}
+		})
+	}
+}
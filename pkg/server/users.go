@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// broker channel carrying the messages sent to users between the server nodes
+const usersChannel = "brisk:users"
+
+// envelope in which a message sent to a user travels over the broker between server nodes
+type userEnvelope struct {
+	Origin  string   `json:"origin"`
+	UserId  string   `json:"user_id"`
+	Message *Message `json:"message"`
+
+	// raw payload of the message, see detachRawPayload
+	Raw []byte `json:"raw"`
+}
+
+// records the client among the clients of the user. Clients without user id are not indexed
+func (ss *SocketServer) indexUser(client *socketClient, userId string) {
+
+	if userId == "" {
+		return
+	}
+
+	ss.clientsLock.Lock()
+	defer ss.clientsLock.Unlock()
+
+	// the client might have been removed while authenticating
+	if _, tracked := ss.clients[client.Id]; !tracked {
+		return
+	}
+
+	client.UserId = userId
+	clients, ok := ss.users[userId]
+	if !ok {
+		clients = make(map[string]*socketClient)
+		ss.users[userId] = clients
+	}
+	clients[client.Id] = client
+}
+
+// forgets the client among the clients of its user. Must hold the clients lock
+func (ss *SocketServer) unindexUser(client *socketClient) {
+
+	clients, ok := ss.users[client.UserId]
+	if !ok {
+		return
+	}
+
+	delete(clients, client.Id)
+	if len(clients) == 0 {
+		delete(ss.users, client.UserId)
+	}
+}
+
+// ids of the clients of the user connected to this node
+func (ss *SocketServer) UserClients(userId string) []string {
+
+	ss.clientsLock.RLock()
+	defer ss.clientsLock.RUnlock()
+
+	ids := make([]string, 0, len(ss.users[userId]))
+	for id := range ss.users[userId] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// delivers the message to every client of the user connected to this node and publishes it on the broker
+// so that the other nodes deliver it to theirs
+func (ss *SocketServer) SendToUser(userId string, msg *Message) error {
+
+	ss.deliverToUser(userId, msg)
+
+	msg, raw := detachRawPayload(msg)
+	envelope, err := json.Marshal(userEnvelope{Origin: ss.nodeId, UserId: userId, Message: msg, Raw: raw})
+	if err != nil {
+		return err
+	}
+	return ss.broker.Publish(context.Background(), usersChannel, envelope)
+}
+
+// pushes the message to the clients of the user connected to this node
+func (ss *SocketServer) deliverToUser(userId string, msg *Message) {
+
+	ss.clientsLock.RLock()
+	clients := make([]*socketClient, 0, len(ss.users[userId]))
+	for _, client := range ss.users[userId] {
+		clients = append(clients, client)
+	}
+	ss.clientsLock.RUnlock()
+
+	for _, client := range clients {
+		if err := ss.pushMessage(client, *msg); err != nil {
+			AppLogger.Errorf("[deliverToUser] user: %s client: %s error occurred while pushing message: %v", userId, client.Id, err)
+		}
+	}
+}
+
+// subscribes to the messages sent to users from the other nodes and delivers them to the local clients
+func (ss *SocketServer) subscribeUsers() {
+
+	subscription, err := ss.broker.Subscribe(context.Background(), usersChannel)
+	if err != nil {
+		AppLogger.Errorf("[subscribeUsers] error occurred while subscribing to user messages: %v", err)
+		return
+	}
+
+	go func() {
+		for data := range subscription {
+
+			var envelope userEnvelope
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				AppLogger.Errorf("[subscribeUsers] error occurred while decoding user message: %v", err)
+				continue
+			}
+
+			// this node already delivered the message to its own clients while publishing it
+			if envelope.Origin == ss.nodeId || envelope.Message == nil {
+				continue
+			}
+			attachRawPayload(envelope.Message, envelope.Raw)
+			ss.deliverToUser(envelope.UserId, envelope.Message)
+		}
+		AppLogger.Infoln("[subscribeUsers] subscription closed")
+	}()
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+// node of a cluster sharing the memory broker, authenticating clients as the user of their payload
+func newUserTestNode(t *testing.T, broker *MemoryBroker) (*SocketServer, string) {
+
+	cfg := DefaultServerConfiguration(":0")
+	cfg.PingInterval = 0
+	ss := NewSocketServer(cfg, broker)
+	ss.AuthHandler = func(req *AuthRequest) (bool, string) {
+		req.UserId, _ = req.Message.Payload["user"].(string)
+		return true, "authenticated as " + req.UserId
+	}
+
+	srv := httptest.NewServer(ss)
+	t.Cleanup(func() {
+		srv.Close()
+		ss.Shutdown(context.Background())
+	})
+	return ss, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// connects a client authenticated as the user and waits for the node to index it
+func dialUser(t *testing.T, ss *SocketServer, url, user string) net.Conn {
+
+	before := len(ss.UserClients(user))
+	conn := dialUnauthenticated(t, url)
+	if err := wsutil.WriteClientText(conn, []byte(fmt.Sprintf(`{"event":"authenticate","payload":{"user":%q}}`, user))); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ss.UserClients(user)) == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("client of %s not indexed", user)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return conn
+}
+
+func TestSendToUserAcrossNodes(t *testing.T) {
+
+	broker := NewMemoryBroker(10)
+	first, firstURL := newUserTestNode(t, broker)
+	second, secondURL := newUserTestNode(t, broker.Connect())
+
+	alice := []net.Conn{
+		dialUser(t, first, firstURL, "alice"),
+		dialUser(t, first, firstURL, "alice"),
+		dialUser(t, second, secondURL, "alice"),
+	}
+	bob := dialUser(t, second, secondURL, "bob")
+
+	// from the node of two of the clients, then from the node of the third one
+	if err := first.SendToUser("alice", &Message{Event: "note", Payload: map[string]interface{}{"n": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SendToUser("alice", &Message{Event: "note", Payload: map[string]interface{}{"n": 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a message sent from another node may arrive after one sent later from the node of the client
+	for i, conn := range alice {
+		received := map[interface{}]bool{}
+		for j := 0; j < 2; j++ {
+			msg := readMessage(t, conn)
+			if msg.Event != "note" {
+				t.Fatalf("client %d: got %s %v", i, msg.Event, msg.Payload)
+			}
+			received[msg.Payload["n"]] = true
+		}
+		if !received[float64(1)] || !received[float64(2)] {
+			t.Fatalf("client %d: got notes %v, want 1 and 2", i, received)
+		}
+	}
+
+	// every client got each message once, the nodes dropping their own messages coming back from the broker
+	for i, conn := range append(alice, bob) {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if data, err := wsutil.ReadServerText(conn); err == nil {
+			t.Fatalf("client %d: got unexpected message %s", i, data)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+
+	// the submitter waits for room in the queue
+	POOL_FULL_POLICY_BLOCK = "block"
+
+	// the task is discarded
+	POOL_FULL_POLICY_DROP = "drop"
+
+	// the task is discarded and the client is sent an error frame
+	POOL_FULL_POLICY_REJECT = "reject"
+
+)
+
+// what happens to a task submitted while every worker is busy and the queue is full
+type PoolFullPolicy string
+
+// returned when a task is not run because the pool is full
+var errPoolFull = errors.New("worker pool is full")
+
+// time after which a worker without any task stops, so that an idle server holds no worker
+const workerIdleTimeout = 10 * time.Second
+
+// snapshot of a worker pool
+type PoolStats struct {
+
+	// running workers, busy or waiting for a task, and the maximum number of them
+	Workers			int
+	MaxWorkers		int
+
+	// tasks waiting for a worker and the maximum number of them
+	QueueDepth		int
+	QueueCapacity	int
+
+	// messages of the clients waiting for the previous messages of their client to be handled, which are
+	// not queued in the pool until then
+	ClientQueueDepth	int
+
+	// tasks submitted since the start, and among them the ones discarded because the pool was full
+	Submitted		uint64
+	Refused			uint64
+}
+
+// bounded pool of goroutines running tasks. Workers are started on demand up to the maximum, a task
+// submitted while all of them are busy is queued, and the policy decides what happens once the queue is full
+type workerPool struct {
+
+	// first in the struct to be 64-bit aligned for the atomic operations
+	submitted  uint64
+	refused    uint64
+
+	// tasks waiting in the serial queues submitting to the pool
+	pending    int64
+
+	tasks      chan func()
+	policy     PoolFullPolicy
+	maxWorkers int32
+
+	workers    int32
+	idle       int32
+}
+
+func newWorkerPool(maxWorkers, queueSize int, policy PoolFullPolicy) *workerPool {
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &workerPool{
+		tasks:      make(chan func(), queueSize),
+		policy:     policy,
+		maxWorkers: int32(maxWorkers),
+	}
+}
+
+// runs the task on an idle worker, on a new one while below the maximum, or queues it. errPoolFull is
+// returned when the queue is full and the policy is not to wait for room in it
+func (p *workerPool) submit(task func()) error {
+
+	atomic.AddUint64(&p.submitted, 1)
+
+	if atomic.LoadInt32(&p.idle) == 0 && p.startWorker(task) {
+		return nil
+	}
+
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+	}
+
+	// the idle workers were not waiting for a task yet
+	if p.startWorker(task) {
+		return nil
+	}
+
+	if p.policy != POOL_FULL_POLICY_BLOCK {
+		atomic.AddUint64(&p.refused, 1)
+		return errPoolFull
+	}
+
+	for {
+		// the workers might all stop while idle before taking it, starting one is then tried again
+		select {
+		case p.tasks <- task:
+			return nil
+		case <-time.After(workerIdleTimeout):
+		}
+
+		if p.startWorker(task) {
+			return nil
+		}
+	}
+}
+
+func (p *workerPool) startWorker(task func()) bool {
+
+	for {
+		workers := atomic.LoadInt32(&p.workers)
+		if workers >= p.maxWorkers {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.workers, workers, workers+1) {
+			go p.work(task)
+			return true
+		}
+	}
+}
+
+func (p *workerPool) work(task func()) {
+
+	idle := time.NewTimer(workerIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		if task != nil {
+			task()
+		}
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(workerIdleTimeout)
+
+		atomic.AddInt32(&p.idle, 1)
+		select {
+		case task = <-p.tasks:
+			atomic.AddInt32(&p.idle, -1)
+		case <-idle.C:
+			atomic.AddInt32(&p.idle, -1)
+			atomic.AddInt32(&p.workers, -1)
+
+			// a task queued while the worker was stopping would otherwise wait for the next submission
+			if len(p.tasks) > 0 {
+				p.startWorker(nil)
+			}
+			return
+		}
+	}
+}
+
+func (p *workerPool) stats() PoolStats {
+	return PoolStats{
+		Workers:          int(atomic.LoadInt32(&p.workers)),
+		MaxWorkers:       int(p.maxWorkers),
+		QueueDepth:       len(p.tasks),
+		QueueCapacity:    cap(p.tasks),
+		ClientQueueDepth: int(atomic.LoadInt64(&p.pending)),
+		Submitted:        atomic.LoadUint64(&p.submitted),
+		Refused:          atomic.LoadUint64(&p.refused),
+	}
+}